@@ -0,0 +1,24 @@
+// Command mockgateway runs a local payment gateway for offline checkout testing.
+package main
+
+import (
+	"ecommerce/gateway"
+	"flag"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address the mock gateway listens on")
+	publicURL := flag.String("public-url", "http://localhost:8090", "URL customers use to reach the checkout page")
+	webhookURL := flag.String("webhook-url", "http://localhost:8080/payments/webhook", "shop endpoint receiving payment webhooks")
+	secret := flag.String("secret", "your-secret-key", "secret used to sign webhooks")
+	flag.Parse()
+
+	server := gateway.NewMockServer(*publicURL, *webhookURL, []byte(*secret))
+
+	log.Printf("Starting mock payment gateway on %s...", *addr)
+	if err := http.ListenAndServe(*addr, server.Handler()); err != nil {
+		log.Fatalf("Mock gateway failed: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"ecommerce/utils"
 	"fmt"
 
 	"github.com/spf13/viper"
@@ -12,6 +13,13 @@ type Config struct {
 	Database Database `mapstructure:"database"`
 	Server   Server   `mapstructure:"server"`
 	Logging  Logging  `mapstructure:"logging"`
+	Payment  Payment  `mapstructure:"payment"`
+	Orders   Orders   `mapstructure:"orders"`
+	Search   Search   `mapstructure:"search"`
+	Cart     Cart     `mapstructure:"cart"`
+	Wishlist Wishlist `mapstructure:"wishlist"`
+	Auth     Auth     `mapstructure:"auth"`
+	JWT      JWT      `mapstructure:"jwt"`
 }
 
 type Kafka struct {
@@ -39,6 +47,78 @@ type Server struct {
 	Port int `mapstructure:"port"`
 }
 
+type Payment struct {
+	Gateway                 string   `mapstructure:"gateway"`                   // payment provider, e.g. "mock"
+	BaseURL                 string   `mapstructure:"base_url"`                  // API address of the provider
+	WebhookSecrets          []string `mapstructure:"webhook_secrets"`           // current secret first, previous one during rotation
+	WebhookToleranceSeconds int      `mapstructure:"webhook_tolerance_seconds"` // maximum age of a webhook timestamp
+	RefundRetryMinutes      int      `mapstructure:"refund_retry_minutes"`      // how often refunds that failed are retried
+	RefundRetryBatchSize    int      `mapstructure:"refund_retry_batch_size"`
+}
+
+type Orders struct {
+	ReservationTTLMinutes int `mapstructure:"reservation_ttl_minutes"` // unpaid orders older than this are canceled
+	SweepIntervalSeconds  int `mapstructure:"sweep_interval_seconds"`
+	SweepBatchSize        int `mapstructure:"sweep_batch_size"`
+}
+
+type Search struct {
+	RefreshIntervalMinutes int       `mapstructure:"refresh_interval_minutes"` // full rebuild of the index from the database
+	PriceBuckets           []float64 `mapstructure:"price_buckets"`            // upper bounds of the price facet buckets
+}
+
+type Wishlist struct {
+	AlertCooldownHours int `mapstructure:"alert_cooldown_hours"` // least time between two back in stock emails for a product
+}
+
+type Cart struct {
+	GuestCartTTLHours             int           `mapstructure:"guest_cart_ttl_hours"`              // guest carts expire when untouched for this long
+	GuestCartSweepIntervalMinutes int           `mapstructure:"guest_cart_sweep_interval_minutes"` // how often expired guest carts are deleted
+	MergeStrategy                 string        `mapstructure:"merge_strategy"`                    // "sum" or "latest", see models.CartMergeSum
+	Reminders                     CartReminders `mapstructure:"reminders"`
+}
+
+type CartReminders struct {
+	CheckIntervalMinutes int   `mapstructure:"check_interval_minutes"` // how often idle carts are looked for
+	IdleHours            []int `mapstructure:"idle_hours"`             // idle time before each reminder, counted from the last cart change
+	MaxReminders         int   `mapstructure:"max_reminders"`          // reminders per cart until it is changed again
+	BatchSize            int   `mapstructure:"batch_size"`             // maximum reminders sent per check
+	LinkTTLHours         int   `mapstructure:"link_ttl_hours"`         // how long the restore link in a reminder works
+}
+
+type Auth struct {
+	AccessTokenTTLMinutes           int       `mapstructure:"access_token_ttl_minutes"`
+	RefreshTokenTTLHours            int       `mapstructure:"refresh_token_ttl_hours"`
+	AppBaseURL                      string    `mapstructure:"app_base_url"` // frontend address used in email links
+	PasswordResetTTLMinutes         int       `mapstructure:"password_reset_ttl_minutes"`
+	EmailVerificationTTLHours       int       `mapstructure:"email_verification_ttl_hours"`
+	RequireVerifiedEmailForCheckout bool      `mapstructure:"require_verified_email_for_checkout"`
+	Login                           Login     `mapstructure:"login"`
+	TwoFactor                       TwoFactor `mapstructure:"two_factor"`
+}
+
+type Login struct {
+	MaxAccountFailures   int  `mapstructure:"max_account_failures"`
+	MaxIPFailures        int  `mapstructure:"max_ip_failures"`
+	LockoutMinutes       int  `mapstructure:"lockout_minutes"`
+	BackoffAfterFailures int  `mapstructure:"backoff_after_failures"`
+	BackoffBaseSeconds   int  `mapstructure:"backoff_base_seconds"`
+	FailureWindowMinutes int  `mapstructure:"failure_window_minutes"`
+	TrustForwardedFor    bool `mapstructure:"trust_forwarded_for"`
+}
+
+type TwoFactor struct {
+	Issuer               string `mapstructure:"issuer"`         // account name shown in authenticator apps
+	EncryptionKey        string `mapstructure:"encryption_key"` // 32 byte AES key for stored TOTP secrets
+	LoginTokenTTLMinutes int    `mapstructure:"login_token_ttl_minutes"`
+	RequiredForAdmins    bool   `mapstructure:"required_for_admins"`
+}
+
+type JWT struct {
+	SigningKeyID string               `mapstructure:"signing_key_id"` // key new tokens are signed with
+	Keys         []utils.JWTKeyConfig `mapstructure:"keys"`           // every key tokens are accepted from
+}
+
 type Logging struct {
 	Level string `mapstructure:"level"`
 }
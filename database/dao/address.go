@@ -0,0 +1,207 @@
+package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"fmt"
+)
+
+const addressColumns = "id, user_id, full_name, phone, line1, line2, city, state, postal_code, country, default_shipping, default_billing, created_date, updated_date"
+
+func scanAddress(row rowScanner) (*models.Address, error) {
+	var address models.Address
+	err := row.Scan(&address.ID, &address.UserID, &address.FullName, &address.Phone, &address.Line1, &address.Line2,
+		&address.City, &address.State, &address.PostalCode, &address.Country,
+		&address.DefaultShipping, &address.DefaultBilling, &address.CreatedDate, &address.UpdatedDate)
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// CreateAddress adds an entry to a user's address book
+func CreateAddress(tx *sql.Tx, address *models.Address) error {
+	query := `INSERT INTO addresses (` + addressColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(query, address.ID, address.UserID, address.FullName, address.Phone, address.Line1, address.Line2,
+		address.City, address.State, address.PostalCode, address.Country,
+		address.DefaultShipping, address.DefaultBilling, address.CreatedDate, address.UpdatedDate)
+	return err
+}
+
+// UpdateAddress replaces an address book entry of a user
+func UpdateAddress(tx *sql.Tx, address *models.Address) error {
+	query := "UPDATE addresses SET full_name = ?, phone = ?, line1 = ?, line2 = ?, city = ?, state = ?, postal_code = ?, country = ?, " +
+		"default_shipping = ?, default_billing = ?, updated_date = ? WHERE id = ? AND user_id = ?"
+	result, err := tx.Exec(query, address.FullName, address.Phone, address.Line1, address.Line2,
+		address.City, address.State, address.PostalCode, address.Country,
+		address.DefaultShipping, address.DefaultBilling, address.UpdatedDate, address.ID, address.UserID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("no address found with id %s", address.ID)
+	}
+	return nil
+}
+
+// ClearDefaultAddresses unsets the default shipping and/or billing address of a user,
+// so that a new default can be set
+func ClearDefaultAddresses(tx *sql.Tx, userID string, shipping, billing bool) error {
+	if shipping {
+		query := "UPDATE addresses SET default_shipping = FALSE WHERE user_id = ? AND default_shipping = TRUE"
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+	if billing {
+		query := "UPDATE addresses SET default_billing = FALSE WHERE user_id = ? AND default_billing = TRUE"
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasAddresses reports whether a user has any address book entry
+func HasAddresses(tx *sql.Tx, userID string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM addresses WHERE user_id = ?)", userID).Scan(&exists)
+	return exists, err
+}
+
+// GetAddresses retrieves a user's address book, defaults first
+func GetAddresses(userID string) ([]*models.Address, error) {
+	query := "SELECT " + addressColumns + " FROM addresses WHERE user_id = ? " +
+		"ORDER BY default_shipping DESC, default_billing DESC, created_date"
+
+	rows, err := database.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []*models.Address{}
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+// GetAddress retrieves an address book entry, provided it belongs to the user
+func GetAddress(userID, ID string) (*models.Address, error) {
+	query := "SELECT " + addressColumns + " FROM addresses WHERE id = ? AND user_id = ?"
+
+	address, err := scanAddress(database.DB.QueryRow(query, ID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no address found with id %s", ID)
+		}
+		return nil, err
+	}
+	return address, nil
+}
+
+// GetDefaultShippingAddress retrieves a user's default shipping address
+func GetDefaultShippingAddress(userID string) (*models.Address, error) {
+	query := "SELECT " + addressColumns + " FROM addresses WHERE user_id = ? AND default_shipping = TRUE"
+
+	address, err := scanAddress(database.DB.QueryRow(query, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no default shipping address found for user %s", userID)
+		}
+		return nil, err
+	}
+	return address, nil
+}
+
+// DeleteAddress removes an address book entry, it reports whether the user had it.
+// When the entry was a default, the most recently added remaining address becomes the
+// new default so that checkout keeps working without an explicit address.
+func DeleteAddress(userID, ID string) (bool, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	var defaultShipping, defaultBilling bool
+	query := "SELECT default_shipping, default_billing FROM addresses WHERE id = ? AND user_id = ? FOR UPDATE"
+	if err := tx.QueryRow(query, ID, userID).Scan(&defaultShipping, &defaultBilling); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM addresses WHERE id = ? AND user_id = ?", ID, userID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if defaultShipping {
+		query := "UPDATE addresses SET default_shipping = TRUE WHERE user_id = ? ORDER BY created_date DESC LIMIT 1"
+		if _, err := tx.Exec(query, userID); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	if defaultBilling {
+		query := "UPDATE addresses SET default_billing = TRUE WHERE user_id = ? ORDER BY created_date DESC LIMIT 1"
+		if _, err := tx.Exec(query, userID); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// DeleteUserAddresses removes a user's whole address book
+func DeleteUserAddresses(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec("DELETE FROM addresses WHERE user_id = ?", userID)
+	return err
+}
+
+// AnonymiseOrderAddresses removes the recipient and street from the shipping address
+// snapshots of a user's orders. City, postal code and country are kept for tax records.
+func AnonymiseOrderAddresses(tx *sql.Tx, userID string) error {
+	query := "UPDATE orders SET shipping_full_name = '', shipping_phone = '', shipping_line1 = '', shipping_line2 = '' " +
+		"WHERE user_id = ? AND shipping_line1 IS NOT NULL"
+	_, err := tx.Exec(query, userID)
+	return err
+}
+
+// orderAddressColumns are the shipping address snapshot columns of orders
+const orderAddressColumns = "shipping_full_name, shipping_phone, shipping_line1, shipping_line2, shipping_city, shipping_state, shipping_postal_code, shipping_country"
+
+// nullAddress scans a shipping address snapshot, which orders placed before
+// addresses were introduced do not have
+type nullAddress struct {
+	fullName, phone, line1, line2, city, state, postalCode, country sql.NullString
+}
+
+func (a *nullAddress) dest() []interface{} {
+	return []interface{}{&a.fullName, &a.phone, &a.line1, &a.line2, &a.city, &a.state, &a.postalCode, &a.country}
+}
+
+func (a *nullAddress) address() *models.PostalAddress {
+	if !a.line1.Valid {
+		return nil
+	}
+	return &models.PostalAddress{
+		FullName:   a.fullName.String,
+		Phone:      a.phone.String,
+		Line1:      a.line1.String,
+		Line2:      a.line2.String,
+		City:       a.city.String,
+		State:      a.state.String,
+		PostalCode: a.postalCode.String,
+		Country:    a.country.String,
+	}
+}
@@ -4,8 +4,18 @@ import (
 	"database/sql"
 	"ecommerce/database"
 	"ecommerce/models"
+	"time"
 )
 
+// cartTable returns the table holding the lines of a cart and the column naming the
+// cart in it. Guest cart lines are kept apart from the lines of users.
+func cartTable(owner models.CartOwner) (table, column, id string) {
+	if owner.IsGuest() {
+		return "guest_cart_items", "guest_cart_id", owner.GuestCartID
+	}
+	return "carts", "user_id", owner.UserID
+}
+
 // CreateCart creates a cart for the user
 func CreateCart(cart *models.Cart) error {
 	query := `INSERT INTO carts (user_id, created_at, updated_at)
@@ -14,21 +24,145 @@ func CreateCart(cart *models.Cart) error {
 	return err
 }
 
-// AddProductToCart adds a product to the user's cart
+// AddProductToCart adds a product to a cart at its current price. If the product is
+// already in the cart the quantity is added to its line instead of creating a second
+// one, and the line takes the current price.
 func AddProductToCart(cart *models.Cart) error {
-	query := `INSERT INTO carts (id, user_id, product_id, quantity, created_date) 
-			VALUES (?,?,?,?,?)`
-	_, err := database.DB.Exec(query, cart.ID, cart.UserID, cart.ProductID, cart.Quantity, cart.CreatedDate)
+	table, column, id := cartTable(cart.Owner())
+	query := `INSERT INTO ` + table + ` (id, ` + column + `, product_id, quantity, price_at_add, created_date, updated_date)
+			SELECT ?, ?, id, ?, price, ?, ? FROM products WHERE id = ? AND deleted_date IS NULL
+			ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity), price_at_add = VALUES(price_at_add), updated_date = VALUES(updated_date)`
+	if _, err := database.DB.Exec(query, cart.ID, id, cart.Quantity, cart.CreatedDate, cart.CreatedDate, cart.ProductID); err != nil {
+		return err
+	}
+	return bumpCartVersion(database.DB, cart.Owner())
+}
+
+// SetCartItemQuantity sets the quantity of a product in a cart, adding the line if
+// needed. The line takes the current price of the product.
+func SetCartItemQuantity(executor database.QueryExecutor, cart *models.Cart) error {
+	table, column, id := cartTable(cart.Owner())
+	query := `INSERT INTO ` + table + ` (id, ` + column + `, product_id, quantity, price_at_add, created_date, updated_date)
+			SELECT ?, ?, id, ?, price, ?, ? FROM products WHERE id = ? AND deleted_date IS NULL
+			ON DUPLICATE KEY UPDATE quantity = VALUES(quantity), price_at_add = VALUES(price_at_add), updated_date = VALUES(updated_date)`
+	if _, err := executor.Exec(query, cart.ID, id, cart.Quantity, cart.CreatedDate, cart.UpdatedDate, cart.ProductID); err != nil {
+		return err
+	}
+	return bumpCartVersion(executor, cart.Owner())
+}
+
+// bumpCartVersion records that a cart changed. Checkout compares the version with the
+// one the customer confirmed.
+func bumpCartVersion(executor database.QueryExecutor, owner models.CartOwner) error {
+	if owner.IsGuest() {
+		_, err := executor.Exec("UPDATE guest_carts SET version = version + 1 WHERE id = ?", owner.GuestCartID)
+		return err
+	}
+
+	query := "INSERT INTO cart_versions (user_id, version, updated_date) VALUES (?, 1, ?) " +
+		"ON DUPLICATE KEY UPDATE version = version + 1, updated_date = VALUES(updated_date)"
+	_, err := executor.Exec(query, owner.UserID, time.Now())
 	return err
 }
 
+// GetCartVersion returns the version of a cart, zero for carts never changed
+func GetCartVersion(executor database.QueryExecutor, owner models.CartOwner) (int, error) {
+	query := "SELECT version FROM cart_versions WHERE user_id = ?"
+	id := owner.UserID
+	if owner.IsGuest() {
+		query = "SELECT version FROM guest_carts WHERE id = ?"
+		id = owner.GuestCartID
+	}
+
+	var version int
+	err := executor.QueryRow(query, id).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// GetCartQuantity returns how many units of a product are in a cart, zero when the
+// product is not in it
+func GetCartQuantity(owner models.CartOwner, productID string) (int, error) {
+	table, column, id := cartTable(owner)
+	query := "SELECT quantity FROM " + table + " WHERE " + column + " = ? AND product_id = ?"
+
+	var quantity int
+	err := database.DB.QueryRow(query, id, productID).Scan(&quantity)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return quantity, err
+}
+
+// GetCartLines retrieves the raw lines of a cart, including products that were deleted
+// since they were added
+func GetCartLines(executor database.QueryExecutor, owner models.CartOwner) ([]*models.Cart, error) {
+	table, column, id := cartTable(owner)
+	query := "SELECT id, product_id, quantity, created_date, COALESCE(updated_date, created_date) FROM " + table + " WHERE " + column + " = ?"
+	rows, err := executor.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []*models.Cart
+	for rows.Next() {
+		line := models.Cart{UserID: owner.UserID, GuestCartID: owner.GuestCartID}
+		if err := rows.Scan(&line.ID, &line.ProductID, &line.Quantity, &line.CreatedDate, &line.UpdatedDate); err != nil {
+			return nil, err
+		}
+		lines = append(lines, &line)
+	}
+	return lines, rows.Err()
+}
+
+// RemoveCartItem removes a product from a cart
+func RemoveCartItem(executor database.QueryExecutor, owner models.CartOwner, productID string) error {
+	table, column, id := cartTable(owner)
+	query := "DELETE FROM " + table + " WHERE " + column + " = ? AND product_id = ?"
+	result, err := executor.Exec(query, id, productID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrCartItemNotFound
+	}
+	return bumpCartVersion(executor, owner)
+}
+
+// ClearCart removes every item from a cart
+func ClearCart(owner models.CartOwner) error {
+	table, column, id := cartTable(owner)
+	query := "DELETE FROM " + table + " WHERE " + column + " = ?"
+	if _, err := database.DB.Exec(query, id); err != nil {
+		return err
+	}
+	return bumpCartVersion(database.DB, owner)
+}
+
 // GetCart retrieves the user's cart
 func GetCartItems(executor database.QueryExecutor, userID string) ([]*models.ProductDetails, error) {
-	query := "SELECT product_id, quantity, name, price " +
-		"FROM carts " +
-		"INNER JOIN products ON carts.product_id=products.id " +
-		"WHERE carts.user_id=?"
-	rows, err := executor.Query(query, userID)
+	return getCartItems(executor, models.CartOwner{UserID: userID})
+}
+
+// GetGuestCartItems retrieves the items of a guest cart
+func GetGuestCartItems(executor database.QueryExecutor, guestCartID string) ([]*models.ProductDetails, error) {
+	return getCartItems(executor, models.CartOwner{GuestCartID: guestCartID})
+}
+
+func getCartItems(executor database.QueryExecutor, owner models.CartOwner) ([]*models.ProductDetails, error) {
+	table, column, id := cartTable(owner)
+	query := "SELECT product_id, quantity, name, price, COALESCE(c.price_at_add, price) " +
+		"FROM " + table + " c " +
+		"INNER JOIN products ON c.product_id=products.id " +
+		"WHERE c." + column + "=? AND products.deleted_date IS NULL " +
+		"ORDER BY c.created_date, c.id"
+	rows, err := executor.Query(query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +171,7 @@ func GetCartItems(executor database.QueryExecutor, userID string) ([]*models.Pro
 	var items []*models.ProductDetails
 	for rows.Next() {
 		var item models.ProductDetails
-		err = rows.Scan(&item.ID, &item.Quantity, &item.Name, &item.Price)
+		err = rows.Scan(&item.ID, &item.Quantity, &item.Name, &item.Price, &item.PriceAtAdd)
 		if err != nil {
 			return nil, err
 		}
@@ -47,8 +181,113 @@ func GetCartItems(executor database.QueryExecutor, userID string) ([]*models.Pro
 	return items, nil
 }
 
+// GetCheckoutLines retrieves the lines of a user's cart with the current price and
+// stock of their products, and locks them until the transaction ends
+func GetCheckoutLines(tx *sql.Tx, userID string) ([]*models.CheckoutLine, error) {
+	query := "SELECT c.product_id, c.quantity, p.name, p.price, COALESCE(c.price_at_add, p.price), " +
+		"p.stock - p.reserved_stock, p.deleted_date IS NOT NULL " +
+		"FROM carts c " +
+		"INNER JOIN products p ON p.id = c.product_id " +
+		"WHERE c.user_id = ? " +
+		"ORDER BY c.created_date, c.id FOR UPDATE"
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []*models.CheckoutLine
+	for rows.Next() {
+		var line models.CheckoutLine
+		err := rows.Scan(&line.ProductID, &line.Quantity, &line.Name, &line.Price, &line.PriceAtAdd, &line.Available, &line.Discontinued)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, &line)
+	}
+	return lines, rows.Err()
+}
+
 func DeleteCartItems(tx *sql.Tx, userID string) error {
 	query := `DELETE FROM carts WHERE user_id = ?`
-	_, err := tx.Exec(query, userID)
+	if _, err := tx.Exec(query, userID); err != nil {
+		return err
+	}
+	return bumpCartVersion(tx, models.CartOwner{UserID: userID})
+}
+
+// GetAvailableStock returns the stock of a product that is not reserved by unpaid orders
+func GetAvailableStock(productID string) (int, error) {
+	query := "SELECT stock - reserved_stock FROM products WHERE id = ? AND deleted_date IS NULL"
+
+	var available int
+	err := database.DB.QueryRow(query, productID).Scan(&available)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, models.ErrProductNotFound
+		}
+		return 0, err
+	}
+	return available, nil
+}
+
+// CreateGuestCart stores a new, empty guest cart
+func CreateGuestCart(cart *models.GuestCart) error {
+	query := "INSERT INTO guest_carts (id, token_hash, expires_date, created_date) VALUES (?, ?, ?, ?)"
+	_, err := database.DB.Exec(query, cart.ID, cart.TokenHash, cart.ExpiresDate, cart.CreatedDate)
+	return err
+}
+
+// GetGuestCart retrieves an unexpired guest cart by the hash of its token
+func GetGuestCart(executor database.QueryExecutor, tokenHash string) (*models.GuestCart, error) {
+	return getGuestCart(executor, "SELECT id, token_hash, expires_date, created_date FROM guest_carts WHERE token_hash = ? AND expires_date > ?", tokenHash)
+}
+
+// GetGuestCartForUpdate retrieves an unexpired guest cart and locks it until commit,
+// so that a cart is merged into an account only once
+func GetGuestCartForUpdate(tx *sql.Tx, tokenHash string) (*models.GuestCart, error) {
+	return getGuestCart(tx, "SELECT id, token_hash, expires_date, created_date FROM guest_carts WHERE token_hash = ? AND expires_date > ? FOR UPDATE", tokenHash)
+}
+
+func getGuestCart(executor database.QueryExecutor, query, tokenHash string) (*models.GuestCart, error) {
+	var cart models.GuestCart
+	err := executor.QueryRow(query, tokenHash, time.Now()).Scan(&cart.ID, &cart.TokenHash, &cart.ExpiresDate, &cart.CreatedDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrGuestCartNotFound
+		}
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// ExtendGuestCart moves the expiry of a guest cart, carts expire when left untouched
+func ExtendGuestCart(ID string, expiresDate time.Time) error {
+	query := "UPDATE guest_carts SET expires_date = ? WHERE id = ?"
+	_, err := database.DB.Exec(query, expiresDate, ID)
+	return err
+}
+
+// DeleteGuestCart removes a guest cart and its lines
+func DeleteGuestCart(tx *sql.Tx, ID string) error {
+	if _, err := tx.Exec("DELETE FROM guest_cart_items WHERE guest_cart_id = ?", ID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM guest_carts WHERE id = ?", ID)
 	return err
 }
+
+// DeleteExpiredGuestCarts removes the guest carts that expired before the given time
+// and returns how many were removed
+func DeleteExpiredGuestCarts(before time.Time) (int64, error) {
+	query := "DELETE gci FROM guest_cart_items gci INNER JOIN guest_carts gc ON gc.id = gci.guest_cart_id WHERE gc.expires_date < ?"
+	if _, err := database.DB.Exec(query, before); err != nil {
+		return 0, err
+	}
+
+	result, err := database.DB.Exec("DELETE FROM guest_carts WHERE expires_date < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
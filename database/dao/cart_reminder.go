@@ -0,0 +1,143 @@
+package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"strings"
+	"time"
+)
+
+// GetAbandonedCarts lists the carts of users who want reminders that are due one,
+// longest idle first. idleBefore[n] is the time a cart must have been left alone since
+// to get reminder n+1, so len(idleBefore) is the most reminders a cart version gets.
+// Idle time runs from the last cart edit, which removing a line counts as, or from the
+// newest line for carts edited before edits were timed. Only lines of products still
+// on sale count.
+func GetAbandonedCarts(idleBefore []time.Time, limit int) ([]*models.AbandonedCart, error) {
+	if len(idleBefore) == 0 {
+		return nil, nil
+	}
+
+	var due strings.Builder
+	args := make([]interface{}, 0, 2*len(idleBefore)+1)
+	due.WriteString("CASE sent")
+	for n, before := range idleBefore {
+		due.WriteString(" WHEN ? THEN ?")
+		args = append(args, n, before)
+	}
+	due.WriteString(" END")
+
+	query := "SELECT user_id, version, last_activity, sent FROM (" +
+		"SELECT c.user_id, COALESCE(cv.version, 0) AS version, " +
+		"COALESCE(GREATEST(MAX(COALESCE(c.updated_date, c.created_date)), cv.updated_date), MAX(COALESCE(c.updated_date, c.created_date))) AS last_activity, " +
+		"(SELECT COUNT(*) FROM cart_reminders cr WHERE cr.user_id = c.user_id AND cr.cart_version = COALESCE(cv.version, 0)) AS sent " +
+		"FROM carts c " +
+		"INNER JOIN products p ON p.id = c.product_id AND p.deleted_date IS NULL " +
+		"INNER JOIN users u ON u.id = c.user_id AND u.deleted_date IS NULL AND u.cart_reminders_opt_out = FALSE " +
+		"LEFT JOIN cart_versions cv ON cv.user_id = c.user_id " +
+		"GROUP BY c.user_id, cv.version, cv.updated_date" +
+		") idle " +
+		"WHERE last_activity < " + due.String() + " " +
+		"ORDER BY last_activity LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var carts []*models.AbandonedCart
+	for rows.Next() {
+		var cart models.AbandonedCart
+		if err := rows.Scan(&cart.UserID, &cart.CartVersion, &cart.LastActivity, &cart.RemindersSent); err != nil {
+			return nil, err
+		}
+		carts = append(carts, &cart)
+	}
+	return carts, rows.Err()
+}
+
+// CreateCartReminder records a reminder for a cart version. It returns false when the
+// reminder was already recorded, e.g. by another instance running the same job.
+func CreateCartReminder(tx *sql.Tx, reminder *models.CartReminder) (bool, error) {
+	query := `INSERT IGNORE INTO cart_reminders (id, user_id, cart_version, reminder_number, restore_token_hash, unsubscribe_token_hash, expires_date, created_date)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, reminder.ID, reminder.UserID, reminder.CartVersion, reminder.ReminderNumber,
+		reminder.RestoreTokenHash, reminder.UnsubscribeTokenHash, reminder.ExpiresDate, reminder.CreatedDate)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CreateCartReminderItems keeps the items a reminder lists so its restore link can
+// bring them back
+func CreateCartReminderItems(tx *sql.Tx, reminderID string, items []*models.ProductDetails) error {
+	query := "INSERT INTO cart_reminder_items (reminder_id, product_id, quantity) VALUES (?, ?, ?)"
+	for _, item := range items {
+		if _, err := tx.Exec(query, reminderID, item.ID, item.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const cartReminderColumns = "id, user_id, cart_version, reminder_number, restore_token_hash, unsubscribe_token_hash, expires_date, created_date"
+
+// GetCartReminderByRestoreToken retrieves a reminder by the hash of its restore token,
+// provided the link has not expired
+func GetCartReminderByRestoreToken(tokenHash string) (*models.CartReminder, error) {
+	query := "SELECT " + cartReminderColumns + " FROM cart_reminders WHERE restore_token_hash = ? AND expires_date > ?"
+	return getCartReminder(query, tokenHash, time.Now())
+}
+
+// GetCartReminderByUnsubscribeToken retrieves a reminder by the hash of its
+// unsubscribe token
+func GetCartReminderByUnsubscribeToken(tokenHash string) (*models.CartReminder, error) {
+	query := "SELECT " + cartReminderColumns + " FROM cart_reminders WHERE unsubscribe_token_hash = ?"
+	return getCartReminder(query, tokenHash)
+}
+
+func getCartReminder(query string, args ...interface{}) (*models.CartReminder, error) {
+	var reminder models.CartReminder
+	err := database.DB.QueryRow(query, args...).Scan(&reminder.ID, &reminder.UserID, &reminder.CartVersion, &reminder.ReminderNumber,
+		&reminder.RestoreTokenHash, &reminder.UnsubscribeTokenHash, &reminder.ExpiresDate, &reminder.CreatedDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrCartReminderNotFound
+		}
+		return nil, err
+	}
+	return &reminder, nil
+}
+
+// GetCartReminderItems retrieves the cart lines a reminder listed
+func GetCartReminderItems(reminder *models.CartReminder) ([]*models.Cart, error) {
+	query := "SELECT product_id, quantity FROM cart_reminder_items WHERE reminder_id = ?"
+	rows, err := database.DB.Query(query, reminder.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*models.Cart
+	for rows.Next() {
+		item := models.Cart{UserID: reminder.UserID}
+		if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteUserCartReminders removes the reminders sent to a user and their items
+func DeleteUserCartReminders(tx *sql.Tx, userID string) error {
+	query := "DELETE FROM cart_reminders WHERE user_id = ?"
+	_, err := tx.Exec(query, userID)
+	return err
+}
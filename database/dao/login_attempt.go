@@ -0,0 +1,77 @@
+package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"strings"
+	"time"
+)
+
+// GetLoginAttempt retrieves the failed login counter of a key, nil if it has none
+func GetLoginAttempt(key string) (*models.LoginAttempt, error) {
+	query := "SELECT attempt_key, failures, last_failed_date, locked_until FROM login_attempts WHERE attempt_key = ?"
+
+	var attempt models.LoginAttempt
+	var lockedUntil sql.NullTime
+	err := database.DB.QueryRow(query, key).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailedDate, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		attempt.LockedUntil = &lockedUntil.Time
+	}
+	return &attempt, nil
+}
+
+// RecordLoginFailure increments the failed login counter of a key and returns it. A
+// counter whose last failure is older than window starts again at 1, so that failures
+// from a shared address do not add up forever.
+func RecordLoginFailure(key string, window time.Duration) (*models.LoginAttempt, error) {
+	// failures is assigned first so that it still sees the previous last_failed_date
+	query := `INSERT INTO login_attempts (attempt_key, failures, last_failed_date) VALUES (?, 1, ?)
+              ON DUPLICATE KEY UPDATE failures = IF(last_failed_date < ?, 1, failures + 1), last_failed_date = VALUES(last_failed_date)`
+	now := time.Now()
+	if _, err := database.DB.Exec(query, key, now, now.Add(-window)); err != nil {
+		return nil, err
+	}
+	return GetLoginAttempt(key)
+}
+
+// LockLoginAttempt blocks logins for a key until the given time. The counter starts
+// again from zero once the lock ends.
+func LockLoginAttempt(key string, until time.Time) error {
+	query := "UPDATE login_attempts SET failures = 0, locked_until = ? WHERE attempt_key = ?"
+	_, err := database.DB.Exec(query, until, key)
+	return err
+}
+
+// ClearLoginAttempts forgets the failed logins and locks of the given keys
+func ClearLoginAttempts(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	query := "DELETE FROM login_attempts WHERE attempt_key IN (?" + strings.Repeat(", ?", len(keys)-1) + ")"
+	_, err := database.DB.Exec(query, args...)
+	return err
+}
+
+// InsertSecurityAuditLog records a security relevant event
+func InsertSecurityAuditLog(entry *models.SecurityAuditLog) error {
+	query := `INSERT INTO security_audit_log (id, user_id, event, detail, ip_address, created_date)
+              VALUES (?, ?, ?, ?, ?, ?)`
+	var userID interface{}
+	if entry.UserID != "" {
+		userID = entry.UserID
+	}
+	_, err := database.DB.Exec(query, entry.ID, userID, entry.Event, entry.Detail, entry.IPAddress, entry.CreatedDate)
+	return err
+}
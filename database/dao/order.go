@@ -6,75 +6,268 @@ import (
 	"ecommerce/models"
 	"ecommerce/utils"
 	"fmt"
+	"time"
 )
 
 // CreateOrder creates a new order
 func CreateOrder(tx *sql.Tx, order *models.Order) error {
-	query := `INSERT INTO orders (id, user_id, total_price, status, created_date)
-              VALUES (?, ?, ?, ?, ?)`
-	_, err := tx.Exec(query, order.ID, order.UserID, order.TotalPrice, order.Status, order.CreatedDate)
+	query := `INSERT INTO orders (id, user_id, total_price, status, created_date, ` + orderAddressColumns + `)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	address := order.ShippingAddress
+	_, err := tx.Exec(query, order.ID, order.UserID, order.TotalPrice, order.Status, order.CreatedDate,
+		address.FullName, address.Phone, address.Line1, address.Line2, address.City, address.State, address.PostalCode, address.Country)
 	return err
 }
 
 func UpdateOrderItems(tx *sql.Tx, orderID string, item *models.ProductDetails) error {
-	query := "INSERT INTO order_items (id, order_id, product_id, product_price, quantity) " +
-		"VALUES (?,?,?,?,?)"
-	_, err := tx.Exec(query, utils.NewID(), orderID, item.ID, item.Price, item.Quantity)
+	query := "INSERT INTO order_items (id, order_id, product_id, product_name, product_price, quantity) " +
+		"VALUES (?,?,?,?,?,?)"
+	_, err := tx.Exec(query, utils.NewID(), orderID, item.ID, item.Name, item.Price, item.Quantity)
 	return err
 }
 
 func GetOrderByID(ID string) (*models.Order, error) {
-	query := "SELECT id, status, total_price FROM orders WHERE id = ?"
+	query := "SELECT id, user_id, status, total_price, created_date, " + orderAddressColumns + " FROM orders WHERE id = ?"
 
 	var order models.Order
-	err := database.DB.QueryRow(query, ID).Scan(&order.ID, &order.Status, &order.TotalPrice)
+	var address nullAddress
+	dest := append([]interface{}{&order.ID, &order.UserID, &order.Status, &order.TotalPrice, &order.CreatedDate}, address.dest()...)
+	err := database.DB.QueryRow(query, ID).Scan(dest...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no orders found with id : %s", ID)
 		}
 		return nil, err
 	}
+	order.ShippingAddress = address.address()
 	return &order, nil
 }
 
-func UpdateOrderStatus(tx *sql.Tx, ID, status string) error {
+// GetOrderForUpdate reads an order inside a transaction and locks its row until commit
+func GetOrderForUpdate(tx *sql.Tx, ID string) (*models.Order, error) {
+	query := "SELECT id, user_id, status, total_price FROM orders WHERE id = ? FOR UPDATE"
+
+	var order models.Order
+	err := tx.QueryRow(query, ID).Scan(&order.ID, &order.UserID, &order.Status, &order.TotalPrice)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no orders found with id : %s", ID)
+		}
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetExpiredOrderIDs lists pending orders created before the given time, oldest first
+func GetExpiredOrderIDs(before time.Time, limit int) ([]string, error) {
+	query := "SELECT id FROM orders WHERE status = ? AND created_date < ? ORDER BY created_date LIMIT ?"
+	rows, err := database.DB.Query(query, models.OrderStatusPending, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// LockExpiredOrder locks a pending order created before the given time. It returns
+// false when the order is no longer pending or is locked by another transaction,
+// which lets several instances sweep concurrently without waiting on each other.
+func LockExpiredOrder(tx *sql.Tx, ID string, before time.Time) (bool, error) {
+	query := "SELECT id FROM orders WHERE id = ? AND status = ? AND created_date < ? FOR UPDATE SKIP LOCKED"
+
+	var id string
+	err := tx.QueryRow(query, ID, models.OrderStatusPending, before).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// TransitionOrderStatus moves an order to a new status if the order state machine
+// allows it and records the change in order_status_history.
+func TransitionOrderStatus(tx *sql.Tx, ID, status, actor, reason string) (*models.OrderStatusTransition, error) {
+	order, err := GetOrderForUpdate(tx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !models.CanTransitionOrder(order.Status, status) {
+		return nil, fmt.Errorf("%w: order %s cannot move from %s to %s", models.ErrInvalidOrderTransition, ID, order.Status, status)
+	}
+
 	query := "UPDATE orders SET status = ? WHERE id = ?"
-	_, err := tx.Exec(query, status, ID)
+	if _, err := tx.Exec(query, status, ID); err != nil {
+		return nil, err
+	}
+
+	transition := models.OrderStatusTransition{
+		ID:          utils.NewID(),
+		OrderID:     ID,
+		FromStatus:  order.Status,
+		ToStatus:    status,
+		Actor:       actor,
+		Reason:      reason,
+		CreatedDate: time.Now(),
+	}
+	if err := InsertOrderStatusHistory(tx, &transition); err != nil {
+		return nil, err
+	}
+	return &transition, nil
+}
+
+// InsertOrderStatusHistory records a status change of an order
+func InsertOrderStatusHistory(tx *sql.Tx, transition *models.OrderStatusTransition) error {
+	query := `INSERT INTO order_status_history (id, order_id, from_status, to_status, actor, reason, created_date)
+              VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(query, transition.ID, transition.OrderID, transition.FromStatus, transition.ToStatus, transition.Actor, transition.Reason, transition.CreatedDate)
 	return err
 }
 
-// GetOrders retrieves all orders for a specific user
-func GetOrders(userID string) ([]models.Order, error) {
-	query := `SELECT * FROM orders WHERE user_id = ?`
-	rows, err := database.DB.Query(query, userID)
+// GetOrderStatusHistory retrieves every status change of an order, oldest first
+func GetOrderStatusHistory(orderID string) ([]*models.OrderStatusTransition, error) {
+	query := "SELECT id, order_id, from_status, to_status, actor, reason, created_date " +
+		"FROM order_status_history WHERE order_id = ? ORDER BY created_date, id"
+	rows, err := database.DB.Query(query, orderID)
 	if err != nil {
 		return nil, err
 	}
-	var orders []models.Order
+	defer rows.Close()
+
+	history := []*models.OrderStatusTransition{}
+	for rows.Next() {
+		var transition models.OrderStatusTransition
+		err := rows.Scan(&transition.ID, &transition.OrderID, &transition.FromStatus, &transition.ToStatus, &transition.Actor, &transition.Reason, &transition.CreatedDate)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, &transition)
+	}
+	return history, rows.Err()
+}
+
+// HasOpenOrders reports whether a user has orders that are still being paid or fulfilled
+func HasOpenOrders(userID string) (bool, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM orders WHERE user_id = ? AND status IN (?, ?, ?, ?))"
+
+	var open bool
+	err := database.DB.QueryRow(query, userID, models.OrderStatusPending, models.OrderStatusPaid, models.OrderStatusPacked, models.OrderStatusShipped).Scan(&open)
+	return open, err
+}
+
+// GetOrders retrieves a page of a user's orders, newest first
+func GetOrders(userID string, filter *models.OrderFilter) (*models.OrderPage, error) {
+	query := "SELECT id, user_id, status, total_price, created_date FROM orders WHERE user_id = ?"
+	args := []interface{}{userID}
+
+	if filter.Status != "" {
+		query += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query += " AND created_date >= ?"
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		query += " AND created_date < ?"
+		args = append(args, filter.To)
+	}
+	if filter.Cursor != "" {
+		parts, err := utils.DecodeCursor(filter.Cursor, 2)
+		if err != nil {
+			return nil, err
+		}
+		createdDate, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		query += " AND (created_date < ? OR (created_date = ? AND id < ?))"
+		args = append(args, createdDate, createdDate, parts[1])
+	}
+
+	// One extra row tells whether there is a next page
+	query += " ORDER BY created_date DESC, id DESC LIMIT ?"
+	args = append(args, filter.Limit+1)
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := models.OrderPage{Orders: []*models.Order{}}
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.ID, order.Status)
+		err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.TotalPrice, &order.CreatedDate)
+		if err != nil {
+			return nil, err
+		}
+		page.Orders = append(page.Orders, &order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Orders) > filter.Limit {
+		page.Orders = page.Orders[:filter.Limit]
+		last := page.Orders[len(page.Orders)-1]
+		page.NextCursor = utils.EncodeCursor(last.CreatedDate.Format(time.RFC3339Nano), last.ID)
+	}
+	return &page, nil
+}
+
+// GetOrderItems retrieves the items of an order as they were when it was placed.
+// Rows created before product names were snapshotted fall back to the current name.
+func GetOrderItems(orderID string) ([]*models.OrderItem, error) {
+	query := "SELECT oi.id, oi.product_id, COALESCE(oi.product_name, p.name, ''), oi.product_price, oi.quantity " +
+		"FROM order_items oi " +
+		"LEFT JOIN products p ON p.id = oi.product_id " +
+		"WHERE oi.order_id = ?"
+	rows, err := database.DB.Query(query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*models.OrderItem{}
+	for rows.Next() {
+		var item models.OrderItem
+		err := rows.Scan(&item.ID, &item.ProductID, &item.ProductName, &item.UnitPrice, &item.Quantity)
 		if err != nil {
 			return nil, err
 		}
-		orders = append(orders, order)
+		items = append(items, &item)
 	}
-	return orders, nil
+	return items, rows.Err()
 }
 
 func GetOrderDetails(orderID string) (*models.OrderDetails, error) {
-	query := "SELECT orders.id, orders.total_price, orders.status, users.id, CONCAT(users.first_name, ' ', users.last_name) AS username, users.email " +
+	query := "SELECT orders.id, orders.total_price, orders.status, users.id, CONCAT(users.first_name, ' ', users.last_name) AS username, users.email, " + orderAddressColumns + " " +
 		"FROM orders " +
 		"INNER JOIN users ON users.id = orders.user_id " +
 		"WHERE orders.id = ?"
 
 	var orderDetails models.OrderDetails
-	err := database.DB.QueryRow(query, orderID).Scan(&orderDetails.ID, &orderDetails.TotalPrice, &orderDetails.Status, &orderDetails.UserID, &orderDetails.Username, &orderDetails.Email)
+	var address nullAddress
+	dest := append([]interface{}{&orderDetails.ID, &orderDetails.TotalPrice, &orderDetails.Status, &orderDetails.UserID, &orderDetails.Username, &orderDetails.Email}, address.dest()...)
+	err := database.DB.QueryRow(query, orderID).Scan(dest...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no orders details found with id : %s", orderID)
 		}
 		return nil, err
 	}
+	orderDetails.ShippingAddress = address.address()
 	return &orderDetails, nil
 }
@@ -1 +1,221 @@
 package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"fmt"
+	"time"
+)
+
+const paymentColumns = "id, order_id, transaction_id, session_id, refund_id, method, payment_status, amount, created_date, updated_date"
+
+// CreatePayment records a new payment attempt for an order
+func CreatePayment(payment *models.PaymentDetails) error {
+	query := `INSERT INTO payments (id, order_id, method, payment_status, amount, created_date, updated_date)
+              VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := database.DB.Exec(query, payment.ID, payment.OrderID, payment.Method, payment.PaymentStatus, payment.Amount, payment.CreatedDate, payment.UpdatedDate)
+	return err
+}
+
+// UpdatePaymentSession links a payment to the checkout session created at the gateway
+func UpdatePaymentSession(ID, sessionID string) error {
+	query := "UPDATE payments SET session_id = ?, updated_date = ? WHERE id = ?"
+	_, err := database.DB.Exec(query, sessionID, time.Now(), ID)
+	return err
+}
+
+// UpdatePaymentStatus sets the status of a single payment
+func UpdatePaymentStatus(ID, status string) error {
+	query := "UPDATE payments SET payment_status = ?, updated_date = ? WHERE id = ?"
+	_, err := database.DB.Exec(query, status, time.Now(), ID)
+	return err
+}
+
+// ExpirePendingPayments marks the open payment attempts of an order as expired
+func ExpirePendingPayments(tx *sql.Tx, orderID string) error {
+	query := "UPDATE payments SET payment_status = ?, updated_date = ? WHERE order_id = ? AND payment_status = ?"
+	_, err := tx.Exec(query, models.PaymentStatusExpired, time.Now(), orderID, models.PaymentStatusPending)
+	return err
+}
+
+// UpdatePaymentFromWebhook settles the latest pending payment of an order with the
+// gateway result. If no payment was initiated for the order a new record is created
+// so that every gateway notification leaves a trace.
+func UpdatePaymentFromWebhook(tx *sql.Tx, webhookData *models.WebhookData, status string) error {
+	now := time.Now()
+	query := "UPDATE payments SET transaction_id = ?, amount = ?, payment_status = ?, updated_date = ? " +
+		"WHERE order_id = ? AND payment_status = ? " +
+		"ORDER BY created_date DESC LIMIT 1"
+	result, err := tx.Exec(query, webhookData.TransactionID, webhookData.Amount, status, now, webhookData.OrderID, models.PaymentStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to update payment: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %v", err)
+	}
+
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	query = `INSERT INTO payments (id, order_id, transaction_id, payment_status, amount, created_date, updated_date)
+              VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, utils.NewID(), webhookData.OrderID, webhookData.TransactionID, status, webhookData.Amount, now, now)
+	return err
+}
+
+// GetSuccessfulPayment retrieves the settled payment of an order
+func GetSuccessfulPayment(tx *sql.Tx, orderID string) (*models.PaymentDetails, error) {
+	query := "SELECT " + paymentColumns + " FROM payments WHERE order_id = ? AND payment_status = ? " +
+		"ORDER BY updated_date DESC LIMIT 1 FOR UPDATE"
+
+	payment, err := scanPayment(tx.QueryRow(query, orderID, models.PaymentStatusSuccess))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no successful payment found for order : %s", orderID)
+		}
+		return nil, err
+	}
+	return payment, nil
+}
+
+// MarkPaymentRefundPending marks a captured payment as owed back to the customer
+func MarkPaymentRefundPending(tx *sql.Tx, ID string) error {
+	query := "UPDATE payments SET payment_status = ?, updated_date = ? WHERE id = ?"
+	_, err := tx.Exec(query, models.PaymentStatusRefundPending, time.Now(), ID)
+	return err
+}
+
+// GetRefundPendingPayments lists payments waiting for a refund that have not been
+// touched since before, oldest first
+func GetRefundPendingPayments(before time.Time, limit int) ([]*models.PaymentDetails, error) {
+	query := "SELECT " + paymentColumns + " FROM payments WHERE payment_status = ? AND updated_date < ? ORDER BY updated_date LIMIT ?"
+	rows, err := database.DB.Query(query, models.PaymentStatusRefundPending, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []*models.PaymentDetails
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// ClaimRefundPendingPayment touches a payment waiting for a refund so that nobody else
+// retries it for a while. It returns false when the payment was refunded or claimed
+// since before, e.g. by another instance.
+func ClaimRefundPendingPayment(ID string, before time.Time) (bool, error) {
+	query := "UPDATE payments SET updated_date = ? WHERE id = ? AND payment_status = ? AND updated_date < ?"
+	result, err := database.DB.Exec(query, time.Now(), ID, models.PaymentStatusRefundPending, before)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %v", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// MarkPaymentRefunded records the refund issued by the gateway for a payment
+func MarkPaymentRefunded(executor database.QueryExecutor, ID, refundID string) error {
+	query := "UPDATE payments SET payment_status = ?, refund_id = ?, updated_date = ? WHERE id = ?"
+	_, err := executor.Exec(query, models.PaymentStatusRefunded, refundID, time.Now(), ID)
+	return err
+}
+
+// MarkWebhookProcessed stores the transaction ID of a webhook. It returns false when
+// the transaction has already been processed, i.e. the gateway redelivered it.
+func MarkWebhookProcessed(tx *sql.Tx, webhookData *models.WebhookData) (bool, error) {
+	query := `INSERT IGNORE INTO processed_webhooks (transaction_id, order_id, status, processed_date)
+              VALUES (?, ?, ?, ?)`
+	result, err := tx.Exec(query, webhookData.TransactionID, webhookData.OrderID, webhookData.Status, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %v", err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// GetPaymentByID retrieves a payment by ID
+func GetPaymentByID(ID string) (*models.PaymentDetails, error) {
+	query := "SELECT " + paymentColumns + " FROM payments WHERE id = ?"
+
+	payment, err := scanPayment(database.DB.QueryRow(query, ID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no payment found with id : %s", ID)
+		}
+		return nil, err
+	}
+	return payment, nil
+}
+
+// GetPaymentByTransactionID retrieves the payment settled with a gateway transaction
+func GetPaymentByTransactionID(transactionID string) (*models.PaymentDetails, error) {
+	query := "SELECT " + paymentColumns + " FROM payments WHERE transaction_id = ?"
+
+	payment, err := scanPayment(database.DB.QueryRow(query, transactionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no payment found with transaction id : %s", transactionID)
+		}
+		return nil, err
+	}
+	return payment, nil
+}
+
+// GetPaymentsByOrderID retrieves every payment attempt of an order, latest first
+func GetPaymentsByOrderID(orderID string) ([]*models.PaymentDetails, error) {
+	query := "SELECT " + paymentColumns + " FROM payments WHERE order_id = ? ORDER BY created_date DESC"
+	rows, err := database.DB.Query(query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*models.PaymentDetails{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPayment(row rowScanner) (*models.PaymentDetails, error) {
+	var payment models.PaymentDetails
+	var transactionID, sessionID, refundID, method sql.NullString
+	err := row.Scan(&payment.ID, &payment.OrderID, &transactionID, &sessionID, &refundID, &method, &payment.PaymentStatus, &payment.Amount, &payment.CreatedDate, &payment.UpdatedDate)
+	if err != nil {
+		return nil, err
+	}
+	payment.TransactionId = transactionID.String
+	payment.SessionID = sessionID.String
+	payment.RefundID = refundID.String
+	payment.Method = method.String
+	return &payment, nil
+}
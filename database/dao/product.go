@@ -4,42 +4,371 @@ import (
 	"database/sql"
 	"ecommerce/database"
 	"ecommerce/models"
+	"ecommerce/utils"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
-// CreateProduct inserts a new product into the database
+// CreateProduct inserts a new product at version 1
 func CreateProduct(product *models.Product) error {
-	query := `INSERT INTO products (id, name, description, price, stock, category, created_date, updated_date)
-              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO products (id, name, description, price, stock, category, version, created_date, updated_date)
+              VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?)`
 	_, err := database.DB.Exec(query, product.ID, product.Name, product.Description, product.Price, product.Stock, product.Category, product.CreatedDate, product.UpdatedDate)
-	return err
+	if err != nil {
+		return err
+	}
+	product.Version = 1
+	return nil
+}
+
+const productColumns = "id, name, description, price, stock, reserved_stock, category, version, created_date, updated_date"
+
+func scanProduct(row rowScanner) (*models.Product, error) {
+	var product models.Product
+	err := row.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Stock, &product.ReservedStock,
+		&product.Category, &product.Version, &product.CreatedDate, &product.UpdatedDate)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// productSorts maps the sort orders of GET /products to their column, products with
+// the same value are ordered by id
+var productSorts = map[string]struct {
+	column string
+	desc   bool
+}{
+	models.ProductSortNewest:    {"created_date", true},
+	models.ProductSortPriceAsc:  {"price", false},
+	models.ProductSortPriceDesc: {"price", true},
+	models.ProductSortName:      {"name", false},
+}
+
+// productSortValue returns the value of the sort column of a product, as stored in cursors
+func productSortValue(sort string, product *models.Product) string {
+	switch sort {
+	case models.ProductSortPriceAsc, models.ProductSortPriceDesc:
+		return strconv.FormatFloat(product.Price, 'f', -1, 64)
+	case models.ProductSortName:
+		return product.Name
+	default:
+		return product.CreatedDate.Format(time.RFC3339Nano)
+	}
+}
+
+// parseProductSortValue is the inverse of productSortValue
+func parseProductSortValue(sort, value string) (interface{}, error) {
+	switch sort {
+	case models.ProductSortPriceAsc, models.ProductSortPriceDesc:
+		return strconv.ParseFloat(value, 64)
+	case models.ProductSortName:
+		return value, nil
+	default:
+		return time.Parse(time.RFC3339Nano, value)
+	}
+}
+
+// fullTextQuery turns free text into a boolean mode MATCH query requiring every
+// word, as a prefix. Characters with a meaning in boolean mode are dropped.
+func fullTextQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = "+" + word + "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// productFilterClause builds the WHERE clause shared by the page and count queries
+func productFilterClause(filter *models.ProductFilter) (string, []interface{}) {
+	clause := " WHERE deleted_date IS NULL"
+	var args []interface{}
+
+	if query := fullTextQuery(filter.Query); query != "" {
+		clause += " AND MATCH(name, description) AGAINST (? IN BOOLEAN MODE)"
+		args = append(args, query)
+	}
+	if filter.Category != "" {
+		clause += " AND category = ?"
+		args = append(args, filter.Category)
+	}
+	if filter.MinPrice != nil {
+		clause += " AND price >= ?"
+		args = append(args, *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		clause += " AND price <= ?"
+		args = append(args, *filter.MaxPrice)
+	}
+	if filter.InStock {
+		clause += " AND stock - reserved_stock > 0"
+	}
+	return clause, args
 }
 
-// GetProducts retrieves all products
-func GetProducts() ([]models.Product, error) {
-	query := `SELECT id, name, description, price, stock, category FROM products`
+// GetProducts retrieves a page of the products matching a filter, and how many match
+func GetProducts(filter *models.ProductFilter) (*models.ProductPage, error) {
+	sort, ok := productSorts[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort : %s", filter.Sort)
+	}
+
+	clause, args := productFilterClause(filter)
+
+	page := models.ProductPage{Products: []*models.Product{}}
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM products"+clause, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	if filter.Cursor != "" {
+		// The sort is part of the cursor so that it cannot be reused with another one
+		parts, err := utils.DecodeCursor(filter.Cursor, 3)
+		if err != nil || parts[0] != filter.Sort {
+			return nil, models.ErrInvalidCursor
+		}
+		value, err := parseProductSortValue(filter.Sort, parts[2])
+		if err != nil {
+			return nil, models.ErrInvalidCursor
+		}
+
+		comparison := ">"
+		if sort.desc {
+			comparison = "<"
+		}
+		clause += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sort.column, comparison)
+		args = append(args, value, value, parts[1])
+	}
+
+	direction := "ASC"
+	if sort.desc {
+		direction = "DESC"
+	}
+	// One extra row tells whether there is a next page
+	query := "SELECT " + productColumns + " FROM products" + clause +
+		fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s LIMIT ?", sort.column, direction)
+	args = append(args, filter.Limit+1)
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Products = append(page.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Products) > filter.Limit {
+		page.Products = page.Products[:filter.Limit]
+		last := page.Products[len(page.Products)-1]
+		page.NextCursor = utils.EncodeCursor(filter.Sort, last.ID, productSortValue(filter.Sort, last))
+	}
+	return &page, nil
+}
+
+// GetProductByID retrieves a product that has not been deleted
+func GetProductByID(ID string) (*models.Product, error) {
+	query := "SELECT " + productColumns + " FROM products WHERE id = ? AND deleted_date IS NULL"
+
+	product, err := scanProduct(database.DB.QueryRow(query, ID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrProductNotFound
+		}
+		return nil, err
+	}
+	return product, nil
+}
+
+// GetAllProducts retrieves every product that has not been deleted, used to build the search index
+func GetAllProducts() ([]*models.Product, error) {
+	query := "SELECT " + productColumns + " FROM products WHERE deleted_date IS NULL"
 	rows, err := database.DB.Query(query)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var products []models.Product
+	products := []*models.Product{}
 	for rows.Next() {
-		var product models.Product
-		err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Stock, &product.Category)
+		product, err := scanProduct(rows)
 		if err != nil {
 			return nil, err
 		}
 		products = append(products, product)
 	}
+	return products, rows.Err()
+}
 
-	return products, nil
+// lockProductVersion locks a product and checks that it is still at the version the
+// caller read. expectedVersion 0 skips the check.
+func lockProductVersion(tx *sql.Tx, ID string, expectedVersion int) (reservedStock int, err error) {
+	query := "SELECT version, reserved_stock FROM products WHERE id = ? AND deleted_date IS NULL FOR UPDATE"
+
+	var version int
+	if err := tx.QueryRow(query, ID).Scan(&version, &reservedStock); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, models.ErrProductNotFound
+		}
+		return 0, err
+	}
+	if expectedVersion != 0 && version != expectedVersion {
+		return 0, models.ErrProductVersionConflict
+	}
+	return reservedStock, nil
+}
+
+// UpdateProduct replaces the catalogue fields of a product and increments its version.
+// It fails with models.ErrProductVersionConflict when the product is no longer at
+// expectedVersion, so that concurrent edits do not overwrite each other. The product
+// as it was before the update is returned.
+func UpdateProduct(product *models.Product, expectedVersion int) (*models.Product, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	reservedStock, err := lockProductVersion(tx, product.ID, expectedVersion)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if product.Stock < reservedStock {
+		tx.Rollback()
+		return nil, models.ErrStockBelowReserved
+	}
+
+	previous, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE id = ?", product.ID))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// updated_date is maintained by the database
+	query := "UPDATE products SET name = ?, description = ?, price = ?, stock = ?, category = ?, version = version + 1 WHERE id = ?"
+	if _, err := tx.Exec(query, product.Name, product.Description, product.Price, product.Stock, product.Category, product.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	updated, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE id = ?", product.ID))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	*product = *updated
+	return previous, nil
+}
+
+// PatchProduct changes the fields given in patch and increments the version of the
+// product. The product is read again under lock, so fields missing from the patch keep
+// their current value, stock moved by orders since the caller read the product in
+// particular. The product as it was before and after the update is returned.
+func PatchProduct(ID string, patch *models.ProductPatch, expectedVersion int) (updated, previous *models.Product, err error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reservedStock, err := lockProductVersion(tx, ID, expectedVersion)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	if patch.Stock != nil && *patch.Stock < reservedStock {
+		tx.Rollback()
+		return nil, nil, models.ErrStockBelowReserved
+	}
+
+	previous, err = scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE id = ?", ID))
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	// Only the given columns are written, updated_date is maintained by the database
+	columns := []string{"version = version + 1"}
+	args := []interface{}{}
+	if patch.Name != nil {
+		columns = append(columns, "name = ?")
+		args = append(args, *patch.Name)
+	}
+	if patch.Description != nil {
+		columns = append(columns, "description = ?")
+		args = append(args, *patch.Description)
+	}
+	if patch.Price != nil {
+		columns = append(columns, "price = ?")
+		args = append(args, *patch.Price)
+	}
+	if patch.Stock != nil {
+		columns = append(columns, "stock = ?")
+		args = append(args, *patch.Stock)
+	}
+	if patch.Category != nil {
+		columns = append(columns, "category = ?")
+		args = append(args, *patch.Category)
+	}
+	query := "UPDATE products SET " + strings.Join(columns, ", ") + " WHERE id = ?"
+	if _, err := tx.Exec(query, append(args, ID)...); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	updated, err = scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE id = ?", ID))
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return updated, previous, nil
+}
+
+// DeleteProduct soft deletes a product. It disappears from the catalogue and carts
+// and cannot be ordered, but order_items referencing it still resolve.
+func DeleteProduct(ID string, expectedVersion int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := lockProductVersion(tx, ID, expectedVersion); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := "UPDATE products SET deleted_date = ?, version = version + 1 WHERE id = ?"
+	if _, err := tx.Exec(query, time.Now(), ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func ReserveStock(tx *sql.Tx, qunatity int, ID string) error {
 	var availableStock int
 	var reservedStock int
-	query := "SELECT stock, reserved_stock FROM products WHERE id = ? FOR UPDATE"
+	query := "SELECT stock, reserved_stock FROM products WHERE id = ? AND deleted_date IS NULL FOR UPDATE"
 	row := tx.QueryRow(query, ID)
 	err := row.Scan(&availableStock, &reservedStock)
 	if err != nil {
@@ -53,7 +382,7 @@ func ReserveStock(tx *sql.Tx, qunatity int, ID string) error {
 		return fmt.Errorf("sufficient stock not available for quantity : %d", qunatity)
 	}
 
-	query = "UPDATE products SET reserved_stock = reserved_stock + ? WHERE id = ?"
+	query = "UPDATE products SET reserved_stock = reserved_stock + ?, version = version + 1 WHERE id = ?"
 	_, err = tx.Exec(query, qunatity, ID)
 	return err
 
@@ -63,7 +392,7 @@ func DeductStockForOrder(tx *sql.Tx, orderID string) error {
 	query := `
         UPDATE products p
 		JOIN order_items oi ON p.id = oi.product_id
-		SET p.stock = p.stock - oi.quantity, p.reserved_stock = p.reserved_stock - oi.quantity
+		SET p.stock = p.stock - oi.quantity, p.reserved_stock = p.reserved_stock - oi.quantity, p.version = p.version + 1
 		WHERE oi.order_id = ? AND p.stock >= oi.quantity AND p.reserved_stock >= oi.quantity
  		`
 	result, err := tx.Exec(query, orderID)
@@ -83,28 +412,104 @@ func DeductStockForOrder(tx *sql.Tx, orderID string) error {
 	return nil
 }
 
-func RestoreReservedStock(tx *sql.Tx, orderID string) error {
+// RestoreReservedStock releases the stock reserved for an unpaid order and returns
+// the products it was released for
+func RestoreReservedStock(tx *sql.Tx, orderID string) ([]*models.StockChange, error) {
+	previous, err := lockOrderProducts(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
         UPDATE products p
         JOIN order_items oi ON p.id = oi.product_id
-        SET p.reserved_stock = p.reserved_stock - oi.quantity
+        SET p.reserved_stock = p.reserved_stock - oi.quantity, p.version = p.version + 1
         WHERE oi.order_id = ? AND p.reserved_stock >= oi.quantity
     `
 	result, err := tx.Exec(query, orderID)
 	if err != nil {
-		return fmt.Errorf("failed to deduct stock: %v", err)
+		return nil, fmt.Errorf("failed to deduct stock: %v", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %v", err)
+		return nil, fmt.Errorf("failed to check rows affected: %v", err)
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("insufficient reserved stock or no matching records for order %s", orderID)
+		return nil, fmt.Errorf("insufficient reserved stock or no matching records for order %s", orderID)
 	}
 
-	return nil
+	return stockChanges(tx, orderID, previous)
+}
+
+// RestoreStockForOrder is the inverse of DeductStockForOrder, it puts the items of a
+// paid order back on the shelf and returns the products that were restocked
+func RestoreStockForOrder(tx *sql.Tx, orderID string) ([]*models.StockChange, error) {
+	previous, err := lockOrderProducts(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        UPDATE products p
+        JOIN order_items oi ON p.id = oi.product_id
+        SET p.stock = p.stock + oi.quantity, p.version = p.version + 1
+        WHERE oi.order_id = ?
+    `
+	result, err := tx.Exec(query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore stock: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("no matching records for order %s", orderID)
+	}
+
+	return stockChanges(tx, orderID, previous)
+}
+
+// lockOrderProducts locks the products of an order, ordered by id
+func lockOrderProducts(tx *sql.Tx, orderID string) ([]*models.Product, error) {
+	query := "SELECT " + productColumns + " FROM products WHERE id IN (SELECT product_id FROM order_items WHERE order_id = ?) ORDER BY id FOR UPDATE"
+	rows, err := tx.Query(query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []*models.Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+// stockChanges reads the products of an order again and pairs them with the state
+// lockOrderProducts returned before their stock moved
+func stockChanges(tx *sql.Tx, orderID string, previous []*models.Product) ([]*models.StockChange, error) {
+	products, err := lockOrderProducts(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) != len(previous) {
+		return nil, fmt.Errorf("products of order %s changed while restoring stock", orderID)
+	}
+
+	changes := make([]*models.StockChange, 0, len(products))
+	for i, product := range products {
+		changes = append(changes, &models.StockChange{Product: product, Previous: previous[i]})
+	}
+	return changes, nil
 }
 
 // func UpdateInventory(item *models.ProductDetails) error {
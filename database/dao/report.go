@@ -0,0 +1,31 @@
+package dao
+
+import (
+	"ecommerce/database"
+	"ecommerce/models"
+	"time"
+)
+
+// GetSalesReport counts the orders created in [from, to) and sums their value per status
+func GetSalesReport(from, to time.Time) (*models.SalesReport, error) {
+	query := "SELECT status, COUNT(*), COALESCE(SUM(total_price), 0) " +
+		"FROM orders " +
+		"WHERE created_date >= ? AND created_date < ? " +
+		"GROUP BY status ORDER BY status"
+	rows, err := database.DB.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := models.SalesReport{From: from, To: to, Statuses: []*models.OrderStatusSummary{}}
+	for rows.Next() {
+		var summary models.OrderStatusSummary
+		if err := rows.Scan(&summary.Status, &summary.Orders, &summary.TotalPrice); err != nil {
+			return nil, err
+		}
+		report.TotalOrders += summary.Orders
+		report.Statuses = append(report.Statuses, &summary)
+	}
+	return &report, rows.Err()
+}
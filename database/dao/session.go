@@ -0,0 +1,112 @@
+package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"fmt"
+	"time"
+)
+
+// CreateSession starts a login session
+func CreateSession(tx *sql.Tx, session *models.Session) error {
+	query := `INSERT INTO sessions (id, user_id, two_factor, created_date) VALUES (?, ?, ?, ?)`
+	_, err := tx.Exec(query, session.ID, session.UserID, session.TwoFactor, session.CreatedDate)
+	return err
+}
+
+// GetSession retrieves a login session by ID
+func GetSession(tx *sql.Tx, ID string) (*models.Session, error) {
+	query := "SELECT id, user_id, two_factor, created_date, revoked_date FROM sessions WHERE id = ?"
+
+	var session models.Session
+	var revokedDate sql.NullTime
+	err := tx.QueryRow(query, ID).Scan(&session.ID, &session.UserID, &session.TwoFactor, &session.CreatedDate, &revokedDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no session found with id %s", ID)
+		}
+		return nil, err
+	}
+	if revokedDate.Valid {
+		session.RevokedDate = &revokedDate.Time
+	}
+	return &session, nil
+}
+
+// CreateRefreshToken stores the hash of a refresh token
+func CreateRefreshToken(tx *sql.Tx, token *models.RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (id, session_id, user_id, token_hash, expires_date, created_date)
+              VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(query, token.ID, token.SessionID, token.UserID, token.TokenHash, token.ExpiresDate, token.CreatedDate)
+	return err
+}
+
+// GetRefreshTokenForUpdate looks a refresh token up by hash and locks it so that it
+// can only be exchanged once. Tokens of revoked sessions are not returned.
+func GetRefreshTokenForUpdate(tx *sql.Tx, tokenHash string) (*models.RefreshToken, error) {
+	query := "SELECT rt.id, rt.session_id, rt.user_id, rt.token_hash, rt.expires_date, rt.used_date, rt.created_date " +
+		"FROM refresh_tokens rt " +
+		"INNER JOIN sessions s ON s.id = rt.session_id " +
+		"WHERE rt.token_hash = ? AND s.revoked_date IS NULL " +
+		"FOR UPDATE"
+
+	var token models.RefreshToken
+	var usedDate sql.NullTime
+	err := tx.QueryRow(query, tokenHash).Scan(&token.ID, &token.SessionID, &token.UserID, &token.TokenHash, &token.ExpiresDate, &usedDate, &token.CreatedDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no active refresh token found")
+		}
+		return nil, err
+	}
+	if usedDate.Valid {
+		token.UsedDate = &usedDate.Time
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed consumes a refresh token after it was exchanged
+func MarkRefreshTokenUsed(tx *sql.Tx, ID string) error {
+	query := "UPDATE refresh_tokens SET used_date = ? WHERE id = ?"
+	_, err := tx.Exec(query, time.Now(), ID)
+	return err
+}
+
+// RevokeSession ends a login session, its refresh tokens and access tokens stop working
+func RevokeSession(sessionID string) error {
+	query := "UPDATE sessions SET revoked_date = ? WHERE id = ? AND revoked_date IS NULL"
+	_, err := database.DB.Exec(query, time.Now(), sessionID)
+	return err
+}
+
+// RevokeUserSessions ends every login session of a user ("log out all devices")
+func RevokeUserSessions(userID string) error {
+	query := "UPDATE sessions SET revoked_date = ? WHERE user_id = ? AND revoked_date IS NULL"
+	_, err := database.DB.Exec(query, time.Now(), userID)
+	return err
+}
+
+// RevokeOtherSessions ends every login session of a user except the given one
+func RevokeOtherSessions(userID, sessionID string) error {
+	query := "UPDATE sessions SET revoked_date = ? WHERE user_id = ? AND id <> ? AND revoked_date IS NULL"
+	_, err := database.DB.Exec(query, time.Now(), userID, sessionID)
+	return err
+}
+
+// RevokeToken adds an access token to the revocation list until it expires
+func RevokeToken(tokenID string, expiresDate time.Time) error {
+	query := `INSERT IGNORE INTO revoked_tokens (jti, expires_date) VALUES (?, ?)`
+	_, err := database.DB.Exec(query, tokenID, expiresDate)
+	return err
+}
+
+// IsTokenRevoked reports whether an access token or the session it belongs to was revoked
+func IsTokenRevoked(tokenID, sessionID string) (bool, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?) " +
+		"OR EXISTS(SELECT 1 FROM sessions WHERE id = ? AND revoked_date IS NOT NULL)"
+
+	var revoked bool
+	err := database.DB.QueryRow(query, tokenID, sessionID).Scan(&revoked)
+	return revoked, err
+}
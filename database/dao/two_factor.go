@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"fmt"
+	"time"
+)
+
+// GetTwoFactor retrieves the TOTP enrollment of a user, nil if the user has none
+func GetTwoFactor(userID string) (*models.TwoFactor, error) {
+	query := "SELECT user_id, secret_encrypted, enabled_date, last_used_step, created_date FROM user_two_factor WHERE user_id = ?"
+
+	var twoFactor models.TwoFactor
+	var enabledDate sql.NullTime
+	err := database.DB.QueryRow(query, userID).Scan(&twoFactor.UserID, &twoFactor.SecretEncrypted, &enabledDate, &twoFactor.LastUsedStep, &twoFactor.CreatedDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if enabledDate.Valid {
+		twoFactor.EnabledDate = &enabledDate.Time
+	}
+	return &twoFactor, nil
+}
+
+// SaveTwoFactorSecret starts an enrollment, replacing a previous one that was never
+// confirmed. A confirmed enrollment is left untouched.
+func SaveTwoFactorSecret(twoFactor *models.TwoFactor) error {
+	query := `INSERT INTO user_two_factor (user_id, secret_encrypted, created_date) VALUES (?, ?, ?)
+              ON DUPLICATE KEY UPDATE
+                  secret_encrypted = IF(enabled_date IS NULL, VALUES(secret_encrypted), secret_encrypted),
+                  created_date = IF(enabled_date IS NULL, VALUES(created_date), created_date)`
+	_, err := database.DB.Exec(query, twoFactor.UserID, twoFactor.SecretEncrypted, twoFactor.CreatedDate)
+	return err
+}
+
+// EnableTwoFactor confirms an enrollment
+func EnableTwoFactor(tx *sql.Tx, userID string, step int64) error {
+	query := "UPDATE user_two_factor SET enabled_date = ?, last_used_step = ? WHERE user_id = ? AND enabled_date IS NULL"
+	result, err := tx.Exec(query, time.Now(), step, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("no pending two-factor enrollment found for user %s", userID)
+	}
+	return nil
+}
+
+// UseTOTPStep records the step of an accepted code. It returns false when a code of
+// the same or a later step was accepted before, which stops a code from being replayed.
+func UseTOTPStep(tx *sql.Tx, userID string, step int64) (bool, error) {
+	query := "UPDATE user_two_factor SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?"
+	result, err := tx.Exec(query, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// DeleteTwoFactor removes the enrollment and recovery codes of a user
+func DeleteTwoFactor(tx *sql.Tx, userID string) error {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM user_two_factor WHERE user_id = ?", userID)
+	return err
+}
+
+// ReplaceRecoveryCodes stores a new set of recovery codes, invalidating the old ones
+func ReplaceRecoveryCodes(tx *sql.Tx, userID string, codes []*models.RecoveryCode) error {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO user_recovery_codes (id, user_id, code_hash, created_date) VALUES (?, ?, ?, ?)`
+	for _, code := range codes {
+		if _, err := tx.Exec(query, code.ID, userID, code.CodeHash, code.CreatedDate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUnusedRecoveryCodesForUpdate retrieves and locks the recovery codes a user can still use
+func GetUnusedRecoveryCodesForUpdate(tx *sql.Tx, userID string) ([]*models.RecoveryCode, error) {
+	query := "SELECT id, user_id, code_hash, created_date FROM user_recovery_codes " +
+		"WHERE user_id = ? AND used_date IS NULL FOR UPDATE"
+
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []*models.RecoveryCode
+	for rows.Next() {
+		var code models.RecoveryCode
+		if err := rows.Scan(&code.ID, &code.UserID, &code.CodeHash, &code.CreatedDate); err != nil {
+			return nil, err
+		}
+		codes = append(codes, &code)
+	}
+	return codes, rows.Err()
+}
+
+// MarkRecoveryCodeUsed consumes a recovery code
+func MarkRecoveryCodeUsed(tx *sql.Tx, ID string) error {
+	query := "UPDATE user_recovery_codes SET used_date = ? WHERE id = ?"
+	_, err := tx.Exec(query, time.Now(), ID)
+	return err
+}
@@ -6,15 +6,16 @@ import (
 	"ecommerce/models"
 	"fmt"
 	"log"
+	"time"
 )
 
 // CreateUser inserts a new user into the database
 func CreateUser(user *models.User) error {
-	query := `INSERT INTO users (id,first_name, last_name, email, password, created_date, updated_date) 
-              VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err := database.DB.Exec(query, user.ID, user.FirstName, user.LastName, user.Email, user.Password, user.CreatedDate, user.UpdatedDate)
+	query := `INSERT INTO users (id,first_name, last_name, email, password, role, created_date, updated_date) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := database.DB.Exec(query, user.ID, user.FirstName, user.LastName, user.Email, user.Password, user.Role, user.CreatedDate, user.UpdatedDate)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("unable to insert user, err : %s", err)
 	}
 	return err
 }
@@ -22,19 +23,23 @@ func CreateUser(user *models.User) error {
 // GetUserByID retrieves a user by ID
 func GetUserByID(id string) (*models.User, error) {
 	var user models.User
-	query := `SELECT id, first_name, last_name, email, password FROM users WHERE id = ?`
+	query := `SELECT id, first_name, last_name, email, pending_email, password, role, email_verified, cart_reminders_opt_out, created_date FROM users WHERE id = ? AND deleted_date IS NULL`
 
 	// Use QueryRow to fetch a single row
 	row := database.DB.QueryRow(query, id)
 
 	// Scan the row into the user struct
-	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password)
+	var pendingEmail sql.NullString
+	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &pendingEmail, &user.Password, &user.Role, &user.EmailVerified, &user.CartRemindersOptOut, &user.CreatedDate)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no user found with id %s", id)
 		}
 		return nil, err
 	}
+	if pendingEmail.Valid {
+		user.PendingEmail = pendingEmail.String
+	}
 
 	return &user, nil
 }
@@ -42,19 +47,95 @@ func GetUserByID(id string) (*models.User, error) {
 // GetUserByEmail retrieves a user by email
 func GetUserByEmail(email string) (*models.User, error) {
 	var user models.User
-	query := `SELECT id, first_name, last_name, email, password FROM users WHERE email = ?`
+	query := `SELECT id, first_name, last_name, email, pending_email, password, role, email_verified, cart_reminders_opt_out, created_date FROM users WHERE email = ? AND deleted_date IS NULL`
 
 	// Use QueryRow to fetch a single row
 	row := database.DB.QueryRow(query, email)
 
 	// Scan the row into the user struct
-	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password)
+	var pendingEmail sql.NullString
+	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &pendingEmail, &user.Password, &user.Role, &user.EmailVerified, &user.CartRemindersOptOut, &user.CreatedDate)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no user found with email %s", email)
 		}
 		return nil, err
 	}
+	if pendingEmail.Valid {
+		user.PendingEmail = pendingEmail.String
+	}
 
 	return &user, nil
 }
+
+// UpdatePassword replaces the password hash of a user
+func UpdatePassword(tx *sql.Tx, userID, hashedPassword string) error {
+	query := "UPDATE users SET password = ?, updated_date = ? WHERE id = ?"
+	_, err := tx.Exec(query, hashedPassword, time.Now(), userID)
+	return err
+}
+
+// MarkEmailVerified records that the user proved ownership of their email address
+func MarkEmailVerified(tx *sql.Tx, userID string) error {
+	query := "UPDATE users SET email_verified = TRUE, updated_date = ? WHERE id = ?"
+	_, err := tx.Exec(query, time.Now(), userID)
+	return err
+}
+
+// UpdateUserName changes the name of a user
+func UpdateUserName(userID, firstName, lastName string) error {
+	query := "UPDATE users SET first_name = ?, last_name = ?, updated_date = ? WHERE id = ? AND deleted_date IS NULL"
+	_, err := database.DB.Exec(query, firstName, lastName, time.Now(), userID)
+	return err
+}
+
+// SetCartRemindersOptOut records whether a user wants abandoned cart reminder emails
+func SetCartRemindersOptOut(userID string, optOut bool) error {
+	query := "UPDATE users SET cart_reminders_opt_out = ?, updated_date = ? WHERE id = ? AND deleted_date IS NULL"
+	_, err := database.DB.Exec(query, optOut, time.Now(), userID)
+	return err
+}
+
+// SetPendingEmail records an email address the user wants to change to. It only
+// replaces the current address once ConfirmPendingEmail proves the user owns it.
+func SetPendingEmail(userID, email string) error {
+	query := "UPDATE users SET pending_email = ?, updated_date = ? WHERE id = ? AND deleted_date IS NULL"
+	_, err := database.DB.Exec(query, email, time.Now(), userID)
+	return err
+}
+
+// ConfirmPendingEmail makes the pending email address of a user their verified address
+func ConfirmPendingEmail(tx *sql.Tx, userID string) error {
+	query := "UPDATE users SET email = pending_email, pending_email = NULL, email_verified = TRUE, updated_date = ? " +
+		"WHERE id = ? AND pending_email IS NOT NULL AND deleted_date IS NULL"
+	result, err := tx.Exec(query, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("no pending email found for user %s", userID)
+	}
+	return nil
+}
+
+// IsEmailTaken reports whether an email address is used, or about to be used, by an
+// account other than userID
+func IsEmailTaken(email, userID string) (bool, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM users WHERE (email = ? OR pending_email = ?) AND id <> ?)"
+
+	var taken bool
+	err := database.DB.QueryRow(query, email, email, userID).Scan(&taken)
+	return taken, err
+}
+
+// DeleteUser soft deletes a user. The row is kept so that orders and payments still
+// reference it, but every personal detail is replaced and the password removed so
+// that the account can no longer be used.
+func DeleteUser(tx *sql.Tx, userID string) error {
+	query := "UPDATE users SET first_name = 'Deleted', last_name = 'User', email = CONCAT('deleted-', id, '@deleted.invalid'), " +
+		"pending_email = NULL, password = '', email_verified = FALSE, deleted_date = ?, updated_date = ? " +
+		"WHERE id = ? AND deleted_date IS NULL"
+	now := time.Now()
+	_, err := tx.Exec(query, now, now, userID)
+	return err
+}
@@ -0,0 +1,60 @@
+package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"fmt"
+	"time"
+)
+
+// CreateUserToken stores a single use token. Older unused tokens of the same user and
+// purpose are invalidated so that only the latest link works.
+func CreateUserToken(token *models.UserToken) error {
+	query := "UPDATE user_tokens SET used_date = ? WHERE user_id = ? AND purpose = ? AND used_date IS NULL"
+	if _, err := database.DB.Exec(query, time.Now(), token.UserID, token.Purpose); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO user_tokens (id, user_id, purpose, token_hash, expires_date, created_date)
+              VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := database.DB.Exec(query, token.ID, token.UserID, token.Purpose, token.TokenHash, token.ExpiresDate, token.CreatedDate)
+	return err
+}
+
+// ConsumeUserToken marks a token as used and returns it, provided it exists for the
+// purpose, has not been used and has not expired
+func ConsumeUserToken(tx *sql.Tx, tokenHash, purpose string) (*models.UserToken, error) {
+	query := "SELECT id, user_id, purpose, token_hash, expires_date, created_date " +
+		"FROM user_tokens " +
+		"WHERE token_hash = ? AND purpose = ? AND used_date IS NULL " +
+		"FOR UPDATE"
+
+	var token models.UserToken
+	err := tx.QueryRow(query, tokenHash, purpose).Scan(&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.ExpiresDate, &token.CreatedDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no valid %s token found", purpose)
+		}
+		return nil, err
+	}
+
+	if time.Now().After(token.ExpiresDate) {
+		return nil, fmt.Errorf("%s token expired", purpose)
+	}
+
+	now := time.Now()
+	query = "UPDATE user_tokens SET used_date = ? WHERE id = ?"
+	if _, err := tx.Exec(query, now, token.ID); err != nil {
+		return nil, err
+	}
+	token.UsedDate = &now
+	return &token, nil
+}
+
+// InvalidateUserTokens marks every unused token of a user as used
+func InvalidateUserTokens(tx *sql.Tx, userID string) error {
+	query := "UPDATE user_tokens SET used_date = ? WHERE user_id = ? AND used_date IS NULL"
+	_, err := tx.Exec(query, time.Now(), userID)
+	return err
+}
@@ -0,0 +1,280 @@
+package dao
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/models"
+	"time"
+)
+
+const wishlistColumns = "w.id, w.user_id, w.name, COALESCE(w.share_token, ''), w.created_date, w.updated_date, " +
+	"(SELECT COUNT(*) FROM wishlist_items wi WHERE wi.wishlist_id = w.id)"
+
+func scanWishlist(row rowScanner) (*models.Wishlist, error) {
+	var wishlist models.Wishlist
+	err := row.Scan(&wishlist.ID, &wishlist.UserID, &wishlist.Name, &wishlist.ShareToken, &wishlist.CreatedDate, &wishlist.UpdatedDate, &wishlist.ItemCount)
+	if err != nil {
+		return nil, err
+	}
+	return &wishlist, nil
+}
+
+// CreateWishlist inserts a new, private wishlist
+func CreateWishlist(wishlist *models.Wishlist) error {
+	query := "INSERT INTO wishlists (id, user_id, name, created_date, updated_date) VALUES (?, ?, ?, ?, ?)"
+	_, err := database.DB.Exec(query, wishlist.ID, wishlist.UserID, wishlist.Name, wishlist.CreatedDate, wishlist.UpdatedDate)
+	return err
+}
+
+// GetWishlists retrieves the wishlists of a user, oldest first
+func GetWishlists(userID string) ([]*models.Wishlist, error) {
+	query := "SELECT " + wishlistColumns + " FROM wishlists w WHERE w.user_id = ? ORDER BY w.created_date, w.id"
+	rows, err := database.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wishlists := []*models.Wishlist{}
+	for rows.Next() {
+		wishlist, err := scanWishlist(rows)
+		if err != nil {
+			return nil, err
+		}
+		wishlists = append(wishlists, wishlist)
+	}
+	return wishlists, rows.Err()
+}
+
+// GetWishlist retrieves a wishlist of a user
+func GetWishlist(userID, ID string) (*models.Wishlist, error) {
+	query := "SELECT " + wishlistColumns + " FROM wishlists w WHERE w.id = ? AND w.user_id = ?"
+	return getWishlist(query, ID, userID)
+}
+
+// GetSharedWishlist retrieves a wishlist by its share token
+func GetSharedWishlist(shareToken string) (*models.Wishlist, error) {
+	query := "SELECT " + wishlistColumns + " FROM wishlists w WHERE w.share_token = ?"
+	return getWishlist(query, shareToken)
+}
+
+func getWishlist(query string, args ...interface{}) (*models.Wishlist, error) {
+	wishlist, err := scanWishlist(database.DB.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrWishlistNotFound
+		}
+		return nil, err
+	}
+	return wishlist, nil
+}
+
+// UpdateWishlist saves the name and share token of a wishlist
+func UpdateWishlist(wishlist *models.Wishlist) error {
+	query := "UPDATE wishlists SET name = ?, share_token = NULLIF(?, ''), updated_date = ? WHERE id = ? AND user_id = ?"
+	_, err := database.DB.Exec(query, wishlist.Name, wishlist.ShareToken, wishlist.UpdatedDate, wishlist.ID, wishlist.UserID)
+	return err
+}
+
+// DeleteWishlist removes a wishlist of a user and its items
+func DeleteWishlist(userID, ID string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM wishlists WHERE id = ? AND user_id = ?", ID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		if err != nil {
+			return err
+		}
+		return models.ErrWishlistNotFound
+	}
+
+	if _, err := tx.Exec("DELETE FROM wishlist_items WHERE wishlist_id = ?", ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddWishlistItem adds a product to a wishlist, adding it again changes nothing
+func AddWishlistItem(wishlistID, productID string, addedDate time.Time) error {
+	query := "INSERT IGNORE INTO wishlist_items (wishlist_id, product_id, created_date) " +
+		"SELECT ?, id, ? FROM products WHERE id = ? AND deleted_date IS NULL"
+	result, err := database.DB.Exec(query, wishlistID, addedDate, productID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// Either the product is already on the list or it does not exist
+		if _, err := GetProductByID(productID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveWishlistItem removes a product from a wishlist
+func RemoveWishlistItem(wishlistID, productID string) error {
+	result, err := database.DB.Exec("DELETE FROM wishlist_items WHERE wishlist_id = ? AND product_id = ?", wishlistID, productID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrWishlistItemNotFound
+	}
+	return nil
+}
+
+// GetWishlistItems retrieves the products on a wishlist, most recently added first.
+// Products deleted from the catalogue are left out.
+func GetWishlistItems(wishlistID string) ([]*models.WishlistItem, error) {
+	query := "SELECT p.id, p.name, p.price, p.stock > p.reserved_stock, wi.created_date " +
+		"FROM wishlist_items wi " +
+		"INNER JOIN products p ON p.id = wi.product_id " +
+		"WHERE wi.wishlist_id = ? AND p.deleted_date IS NULL " +
+		"ORDER BY wi.created_date DESC, p.id"
+	rows, err := database.DB.Query(query, wishlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*models.WishlistItem{}
+	for rows.Next() {
+		var item models.WishlistItem
+		if err := rows.Scan(&item.ProductID, &item.Name, &item.Price, &item.InStock, &item.AddedDate); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
+
+// GetWishlistWatchers retrieves the users with a product on any of their wishlists,
+// each user once
+func GetWishlistWatchers(productID string) ([]*models.User, error) {
+	query := "SELECT DISTINCT u.id, u.first_name, u.email " +
+		"FROM wishlist_items wi " +
+		"INNER JOIN wishlists w ON w.id = wi.wishlist_id " +
+		"INNER JOIN users u ON u.id = w.user_id " +
+		"WHERE wi.product_id = ? AND u.deleted_date IS NULL"
+	rows, err := database.DB.Query(query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.FirstName, &user.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// ClaimBackInStockAlert records that a user is told a product is back in stock. It
+// returns false when the user was already told within cooldown, e.g. because the
+// stock was reserved and released again since.
+func ClaimBackInStockAlert(userID, productID string, cooldown time.Duration) (bool, error) {
+	now := time.Now()
+	query := `INSERT INTO wishlist_alerts (user_id, product_id, back_in_stock_date) VALUES (?, ?, ?)
+              ON DUPLICATE KEY UPDATE back_in_stock_date = IF(back_in_stock_date < ?, VALUES(back_in_stock_date), back_in_stock_date)`
+	result, err := database.DB.Exec(query, userID, productID, now, now.Add(-cooldown))
+	if err != nil {
+		return false, err
+	}
+
+	// No row is affected when the alert is still within its cooldown
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteUserWishlists removes every wishlist of a user
+func DeleteUserWishlists(tx *sql.Tx, userID string) error {
+	query := "DELETE wi FROM wishlist_items wi INNER JOIN wishlists w ON w.id = wi.wishlist_id WHERE w.user_id = ?"
+	if _, err := tx.Exec(query, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM wishlists WHERE user_id = ?", userID)
+	return err
+}
+
+// SaveForLater adds a quantity of a product to the user's saved for later list
+func SaveForLater(tx *sql.Tx, userID, productID string, quantity int, savedDate time.Time) error {
+	query := "INSERT INTO saved_items (user_id, product_id, quantity, created_date) VALUES (?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity), created_date = VALUES(created_date)"
+	_, err := tx.Exec(query, userID, productID, quantity, savedDate)
+	return err
+}
+
+// GetSavedQuantity returns how many units of a product the user saved for later, zero
+// when it is not saved
+func GetSavedQuantity(userID, productID string) (int, error) {
+	var quantity int
+	err := database.DB.QueryRow("SELECT quantity FROM saved_items WHERE user_id = ? AND product_id = ?", userID, productID).Scan(&quantity)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return quantity, err
+}
+
+// GetSavedItems retrieves the products a user saved for later, most recent first
+func GetSavedItems(userID string) ([]*models.SavedItem, error) {
+	query := "SELECT p.id, p.name, p.price, s.quantity, p.stock > p.reserved_stock, s.created_date " +
+		"FROM saved_items s " +
+		"INNER JOIN products p ON p.id = s.product_id " +
+		"WHERE s.user_id = ? AND p.deleted_date IS NULL " +
+		"ORDER BY s.created_date DESC, p.id"
+	rows, err := database.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*models.SavedItem{}
+	for rows.Next() {
+		var item models.SavedItem
+		if err := rows.Scan(&item.ProductID, &item.Name, &item.Price, &item.Quantity, &item.InStock, &item.SavedDate); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
+
+// RemoveSavedItem removes a product from the user's saved for later list
+func RemoveSavedItem(executor database.QueryExecutor, userID, productID string) error {
+	result, err := executor.Exec("DELETE FROM saved_items WHERE user_id = ? AND product_id = ?", userID, productID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrSavedItemNotFound
+	}
+	return nil
+}
+
+// DeleteUserSavedItems removes the saved for later list of a user
+func DeleteUserSavedItems(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec("DELETE FROM saved_items WHERE user_id = ?", userID)
+	return err
+}
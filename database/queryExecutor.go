@@ -2,6 +2,10 @@ package database
 
 import "database/sql"
 
+// QueryExecutor is implemented by both *sql.DB and *sql.Tx, so DAO functions taking
+// it can run inside or outside a transaction
 type QueryExecutor interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
 }
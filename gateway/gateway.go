@@ -0,0 +1,67 @@
+package gateway
+
+import "fmt"
+
+// Checkout session statuses reported by a gateway
+const (
+	SessionStatusOpen    = "open"
+	SessionStatusSuccess = "success"
+	SessionStatusFailed  = "failed"
+	SessionStatusExpired = "expired"
+)
+
+// PaymentGateway is implemented by every payment provider the application can charge through.
+type PaymentGateway interface {
+	// CreateCheckoutSession registers a payment with the provider and returns the hosted checkout link
+	CreateCheckoutSession(request *CheckoutRequest) (*CheckoutSession, error)
+	// FetchStatus returns the current state of a checkout session
+	FetchStatus(sessionID string) (*SessionStatus, error)
+	// Refund returns money for a settled transaction
+	Refund(request *RefundRequest) (*RefundResult, error)
+}
+
+type CheckoutRequest struct {
+	PaymentID string  `json:"payment_id"`
+	OrderID   string  `json:"order_id"`
+	Amount    float32 `json:"amount"`
+	Method    string  `json:"method"`
+}
+
+type CheckoutSession struct {
+	ID          string `json:"id"`
+	CheckoutURL string `json:"checkout_url"`
+	Status      string `json:"status"`
+}
+
+type SessionStatus struct {
+	SessionID     string  `json:"session_id"`
+	PaymentID     string  `json:"payment_id"`
+	OrderID       string  `json:"order_id"`
+	TransactionID string  `json:"transaction_id"`
+	Amount        float32 `json:"amount"`
+	Status        string  `json:"status"`
+}
+
+type RefundRequest struct {
+	TransactionID  string  `json:"transaction_id"`
+	Amount         float32 `json:"amount"`
+	Reason         string  `json:"reason"`
+	IdempotencyKey string  `json:"idempotency_key"` // a retried refund with the same key is only issued once
+}
+
+type RefundResult struct {
+	ID            string  `json:"id"`
+	TransactionID string  `json:"transaction_id"`
+	Amount        float32 `json:"amount"`
+	Status        string  `json:"status"`
+}
+
+// New returns the gateway implementation configured under payment.gateway
+func New(provider, baseURL string) (PaymentGateway, error) {
+	switch provider {
+	case "mock", "":
+		return NewMockGateway(baseURL), nil
+	default:
+		return nil, fmt.Errorf("unsupported payment gateway : %s", provider)
+	}
+}
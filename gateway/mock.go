@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MockGateway talks to the mock gateway server started by cmd/mockgateway.
+type MockGateway struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewMockGateway(baseURL string) *MockGateway {
+	return &MockGateway{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (m *MockGateway) CreateCheckoutSession(request *CheckoutRequest) (*CheckoutSession, error) {
+	var session CheckoutSession
+	if err := m.do(http.MethodPost, "/api/sessions", request, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (m *MockGateway) FetchStatus(sessionID string) (*SessionStatus, error) {
+	var status SessionStatus
+	if err := m.do(http.MethodGet, "/api/sessions/"+sessionID, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (m *MockGateway) Refund(request *RefundRequest) (*RefundResult, error) {
+	var result RefundResult
+	if err := m.do(http.MethodPost, "/api/refunds", request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (m *MockGateway) do(method, path string, body, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, m.baseURL+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mock gateway request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("mock gateway returned status %s for %s %s", resp.Status, method, path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
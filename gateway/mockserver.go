@@ -0,0 +1,249 @@
+package gateway
+
+import (
+	"bytes"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Outcomes a tester can pick on the mock checkout page
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeTimeout = "timeout"
+)
+
+// MockServer is a stand-in payment provider. It hosts a checkout page where the
+// tester decides how the payment ends and delivers signed webhooks to the shop.
+type MockServer struct {
+	publicURL     string
+	webhookURL    string
+	webhookSecret []byte
+	client        *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]*SessionStatus
+	refunds  map[string]*RefundResult // by idempotency key
+}
+
+func NewMockServer(publicURL, webhookURL string, webhookSecret []byte) *MockServer {
+	return &MockServer{
+		publicURL:     strings.TrimRight(publicURL, "/"),
+		webhookURL:    webhookURL,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		sessions:      make(map[string]*SessionStatus),
+		refunds:       make(map[string]*RefundResult),
+	}
+}
+
+// Handler returns the routes served by the mock gateway
+func (s *MockServer) Handler() http.Handler {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/api/sessions", s.createSession).Methods("POST")
+	router.HandleFunc("/api/sessions/{id}", s.getSession).Methods("GET")
+	router.HandleFunc("/api/refunds", s.refund).Methods("POST")
+
+	router.HandleFunc("/checkout/{id}", s.checkoutPage).Methods("GET")
+	router.HandleFunc("/checkout/{id}", s.completeCheckout).Methods("POST")
+	return router
+}
+
+func (s *MockServer) createSession(w http.ResponseWriter, r *http.Request) {
+	var request CheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	session := &SessionStatus{
+		SessionID: "cs_" + utils.NewID(),
+		PaymentID: request.PaymentID,
+		OrderID:   request.OrderID,
+		Amount:    request.Amount,
+		Status:    SessionStatusOpen,
+	}
+
+	s.mu.Lock()
+	s.sessions[session.SessionID] = session
+	s.mu.Unlock()
+
+	log.Printf("created checkout session %s for order %s", session.SessionID, session.OrderID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CheckoutSession{
+		ID:          session.SessionID,
+		CheckoutURL: fmt.Sprintf("%s/checkout/%s", s.publicURL, session.SessionID),
+		Status:      session.Status,
+	})
+}
+
+func (s *MockServer) getSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(session)
+}
+
+func (s *MockServer) refund(w http.ResponseWriter, r *http.Request) {
+	var request RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if refund, ok := s.refunds[request.IdempotencyKey]; ok && request.IdempotencyKey != "" {
+		json.NewEncoder(w).Encode(refund)
+		return
+	}
+
+	var settled *SessionStatus
+	for _, session := range s.sessions {
+		if session.TransactionID != "" && session.TransactionID == request.TransactionID {
+			settled = session
+			break
+		}
+	}
+
+	if settled == nil || settled.Status != SessionStatusSuccess {
+		http.Error(w, "Transaction not refundable", http.StatusUnprocessableEntity)
+		return
+	}
+
+	log.Printf("refunded %.2f of transaction %s", request.Amount, request.TransactionID)
+	refund := &RefundResult{
+		ID:            "rf_" + utils.NewID(),
+		TransactionID: request.TransactionID,
+		Amount:        request.Amount,
+		Status:        "refunded",
+	}
+	if request.IdempotencyKey != "" {
+		s.refunds[request.IdempotencyKey] = refund
+	}
+	json.NewEncoder(w).Encode(refund)
+}
+
+var checkoutTemplate = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock Payment Gateway</title></head>
+<body>
+<h1>Mock Payment Gateway</h1>
+<p>Order: {{.OrderID}}</p>
+<p>Amount: Rs {{printf "%.2f" .Amount}}</p>
+<p>Status: {{.Status}}</p>
+{{if eq .Status "open"}}
+<form method="POST">
+	<button name="outcome" value="success">Pay successfully</button>
+	<button name="outcome" value="failure">Fail payment</button>
+	<button name="outcome" value="timeout">Abandon (timeout)</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+func (s *MockServer) checkoutPage(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	checkoutTemplate.Execute(w, session)
+}
+
+func (s *MockServer) completeCheckout(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+	outcome := r.FormValue("outcome")
+
+	s.mu.Lock()
+	session, ok := s.sessions[sessionID]
+	if !ok || session.Status != SessionStatusOpen {
+		s.mu.Unlock()
+		http.Error(w, "Session not found or already completed", http.StatusConflict)
+		return
+	}
+
+	switch outcome {
+	case OutcomeSuccess:
+		session.Status = SessionStatusSuccess
+		session.TransactionID = "txn_" + utils.NewID()
+	case OutcomeFailure:
+		session.Status = SessionStatusFailed
+		session.TransactionID = "txn_" + utils.NewID()
+	case OutcomeTimeout:
+		// The customer walked away, the provider never reports back
+		session.Status = SessionStatusExpired
+	default:
+		s.mu.Unlock()
+		http.Error(w, "Unknown outcome", http.StatusBadRequest)
+		return
+	}
+	snapshot := *session
+	s.mu.Unlock()
+
+	if snapshot.Status != SessionStatusExpired {
+		if err := s.deliverWebhook(&snapshot); err != nil {
+			log.Printf("unable to deliver webhook for session %s, err : %s", sessionID, err)
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s/checkout/%s", s.publicURL, sessionID), http.StatusSeeOther)
+}
+
+func (s *MockServer) deliverWebhook(session *SessionStatus) error {
+	payload, err := json.Marshal(models.WebhookData{
+		TransactionID: session.TransactionID,
+		OrderID:       session.OrderID,
+		Amount:        session.Amount,
+		Status:        session.Status,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", utils.SignWebhook(s.webhookSecret, timestamp, payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	log.Printf("webhook for session %s delivered, response status: %v", session.SessionID, resp.Status)
+	return nil
+}
+
+func (s *MockServer) session(sessionID string) (SessionStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return SessionStatus{}, false
+	}
+	return *session, true
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/kafka"
+	"ecommerce/middleware"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const minPasswordLength = 8
+
+// ForgotPassword handles sending a password reset link. It answers the same way
+// whether or not the email is registered so that accounts cannot be enumerated.
+func (u *User) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := dao.GetUserByEmail(request.Email)
+	if err == nil {
+		link, err := u.newUserTokenLink(user.ID, models.TokenPurposePasswordReset, u.config.PasswordResetTTL, "/reset-password")
+		if err != nil {
+			log.Printf("unable to create password reset token for user %s, err : %s", user.ID, err)
+			http.Error(w, "Unable to reset password", http.StatusInternalServerError)
+			return
+		}
+
+		u.producer.PublishUserNotification(&kafka.UserNotificationEvent{
+			Event:     kafka.EventPasswordReset,
+			UserID:    user.ID,
+			FirstName: user.FirstName,
+			Email:     user.Email,
+			Link:      link,
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "If the email is registered, a reset link has been sent."})
+}
+
+// ResetPassword handles setting a new password with a token from ForgotPassword.
+// Every session of the user is revoked afterwards.
+func (u *User) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if len(request.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(request.Password)
+	if err != nil {
+		log.Printf("unable to hash password, err : %s", err)
+		http.Error(w, "Unable to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := dao.ConsumeUserToken(tx, utils.HashToken(request.Token), models.TokenPurposePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if err := dao.UpdatePassword(tx, token.UserID, hashedPassword); err != nil {
+		tx.Rollback()
+		log.Printf("unable to update password of user %s, err : %s", token.UserID, err)
+		http.Error(w, "Unable to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit password reset, err : %s", err)
+		http.Error(w, "Unable to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.RevokeUserSessions(token.UserID); err != nil {
+		log.Printf("unable to revoke sessions of user %s, err : %s", token.UserID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail handles confirming an email address with a token from the verification email
+func (u *User) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := dao.ConsumeUserToken(tx, utils.HashToken(request.Token), models.TokenPurposeEmailVerification)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if err := dao.MarkEmailVerified(tx, token.UserID); err != nil {
+		tx.Rollback()
+		log.Printf("unable to verify email of user %s, err : %s", token.UserID, err)
+		http.Error(w, "Unable to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit email verification, err : %s", err)
+		http.Error(w, "Unable to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendEmailVerification handles sending a new verification link to the caller
+func (u *User) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := dao.GetUserByID(middleware.UserID(r))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if user.EmailVerified {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	if err := u.sendEmailVerification(user); err != nil {
+		log.Printf("unable to send email verification to user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (u *User) sendEmailVerification(user *models.User) error {
+	link, err := u.newUserTokenLink(user.ID, models.TokenPurposeEmailVerification, u.config.EmailVerificationTTL, "/verify-email")
+	if err != nil {
+		return err
+	}
+
+	return u.producer.PublishUserNotification(&kafka.UserNotificationEvent{
+		Event:     kafka.EventEmailVerification,
+		UserID:    user.ID,
+		FirstName: user.FirstName,
+		Email:     user.Email,
+		Link:      link,
+	})
+}
+
+// newUserTokenLink stores a new single use token and returns the frontend link carrying it
+func (u *User) newUserTokenLink(userID, purpose string, ttl time.Duration, path string) (string, error) {
+	token, err := newUserToken(userID, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return u.config.AppBaseURL + path + "?token=" + url.QueryEscape(token), nil
+}
+
+// newUserToken stores a single use token for the user and returns the plain token
+func newUserToken(userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := utils.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = dao.CreateUserToken(&models.UserToken{
+		ID:          utils.NewID(),
+		UserID:      userID,
+		Purpose:     purpose,
+		TokenHash:   utils.HashToken(token),
+		ExpiresDate: time.Now().Add(ttl),
+		CreatedDate: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/middleware"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// decodeAddressRequest reads and validates the body of an address book request
+func decodeAddressRequest(r *http.Request) (*models.AddressRequest, error) {
+	var request models.AddressRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errors.New("Invalid input")
+	}
+
+	address := &request.PostalAddress
+	for _, field := range []*string{&address.FullName, &address.Phone, &address.Line1, &address.Line2, &address.City, &address.State, &address.PostalCode, &address.Country} {
+		*field = strings.TrimSpace(*field)
+	}
+	address.Country = strings.ToUpper(address.Country)
+
+	switch {
+	case address.FullName == "":
+		return nil, errors.New("full_name is required")
+	case address.Line1 == "":
+		return nil, errors.New("line1 is required")
+	case address.City == "":
+		return nil, errors.New("city is required")
+	case address.PostalCode == "":
+		return nil, errors.New("postal_code is required")
+	case len(address.Country) != 2:
+		return nil, errors.New("country must be a two letter country code")
+	}
+	return &request, nil
+}
+
+// saveAddress creates or replaces an address book entry. Marking it as a default
+// unsets the previous default; a user's first address becomes both defaults.
+func saveAddress(address *models.Address, create bool) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if create {
+		exists, err := dao.HasAddresses(tx, address.UserID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if !exists {
+			address.DefaultShipping, address.DefaultBilling = true, true
+		}
+	}
+
+	if err := dao.ClearDefaultAddresses(tx, address.UserID, address.DefaultShipping, address.DefaultBilling); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if create {
+		err = dao.CreateAddress(tx, address)
+	} else {
+		err = dao.UpdateAddress(tx, address)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAddresses handles listing the caller's address book
+func GetAddresses(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+
+	addresses, err := dao.GetAddresses(userID)
+	if err != nil {
+		log.Printf("unable to fetch addresses of user %s, err : %s", userID, err)
+		http.Error(w, "Unable to fetch addresses", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]*models.AddressResponse, 0, len(addresses))
+	for _, address := range addresses {
+		response = append(response, models.NewAddressResponse(address))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetAddress handles fetching one entry of the caller's address book
+func GetAddress(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	address, err := dao.GetAddress(middleware.UserID(r), vars["id"])
+	if err != nil {
+		http.Error(w, "Address not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(models.NewAddressResponse(address))
+}
+
+// CreateAddress handles adding an entry to the caller's address book
+func CreateAddress(w http.ResponseWriter, r *http.Request) {
+	request, err := decodeAddressRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	address := models.Address{
+		ID:              utils.NewID(),
+		UserID:          middleware.UserID(r),
+		PostalAddress:   request.PostalAddress,
+		DefaultShipping: request.DefaultShipping,
+		DefaultBilling:  request.DefaultBilling,
+		CreatedDate:     time.Now(),
+		UpdatedDate:     time.Now(),
+	}
+
+	if err := saveAddress(&address, true); err != nil {
+		log.Printf("unable to create address for user %s, err : %s", address.UserID, err)
+		http.Error(w, "Unable to create address", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.NewAddressResponse(&address))
+}
+
+// UpdateAddress handles replacing an entry of the caller's address book. Orders
+// already placed keep the address they were placed with.
+func UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	request, err := decodeAddressRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	address, err := dao.GetAddress(middleware.UserID(r), vars["id"])
+	if err != nil {
+		http.Error(w, "Address not found", http.StatusNotFound)
+		return
+	}
+
+	address.PostalAddress = request.PostalAddress
+	address.DefaultShipping = request.DefaultShipping
+	address.DefaultBilling = request.DefaultBilling
+	address.UpdatedDate = time.Now()
+
+	if err := saveAddress(address, false); err != nil {
+		log.Printf("unable to update address %s, err : %s", address.ID, err)
+		http.Error(w, "Unable to update address", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(models.NewAddressResponse(address))
+}
+
+// DeleteAddress handles removing an entry from the caller's address book
+func DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	deleted, err := dao.DeleteAddress(middleware.UserID(r), vars["id"])
+	if err != nil {
+		log.Printf("unable to delete address %s, err : %s", vars["id"], err)
+		http.Error(w, "Unable to delete address", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Address not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUserAddresses removes the address book of a closed account and the street
+// level details from the addresses its orders were shipped to
+func deleteUserAddresses(tx *sql.Tx, userID string) error {
+	if err := dao.DeleteUserAddresses(tx, userID); err != nil {
+		return err
+	}
+	return dao.AnonymiseOrderAddresses(tx, userID)
+}
@@ -3,9 +3,12 @@ package handlers
 import (
 	"ecommerce/database"
 	"ecommerce/database/dao"
+	"ecommerce/middleware"
 	"ecommerce/models"
 	"ecommerce/utils"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -13,43 +16,299 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// AddToCart handles adding a product to a user's cart
-func AddToCart(w http.ResponseWriter, r *http.Request) {
-	var cart models.Cart
-	if err := json.NewDecoder(r.Body).Decode(&cart); err != nil {
+// Header carrying the token of a guest cart, in requests and when a cart is created
+const cartTokenHeader = "X-Cart-Token"
+
+type Cart struct {
+	config *CartConfig
+}
+
+// CartConfig controls guest carts
+type CartConfig struct {
+	GuestCartTTL  time.Duration // guest carts expire when untouched for this long
+	MergeStrategy string        // models.CartMergeSum or models.CartMergeLatest
+}
+
+func NewCart(config *CartConfig) *Cart {
+	return &Cart{config: config}
+}
+
+// cartOwner returns the cart of the request: the user's cart when signed in, otherwise
+// the guest cart named by the X-Cart-Token header. found is false for guests without
+// a valid token.
+func (c *Cart) cartOwner(r *http.Request) (owner models.CartOwner, found bool, err error) {
+	if userID := middleware.UserID(r); userID != "" {
+		return models.CartOwner{UserID: userID}, true, nil
+	}
+
+	token := r.Header.Get(cartTokenHeader)
+	if token == "" {
+		return owner, false, nil
+	}
+
+	guestCart, err := dao.GetGuestCart(database.DB, utils.HashToken(token))
+	if err != nil {
+		if errors.Is(err, models.ErrGuestCartNotFound) {
+			return owner, false, nil
+		}
+		return owner, false, err
+	}
+
+	// Every visit keeps the cart alive for another TTL
+	if err := dao.ExtendGuestCart(guestCart.ID, time.Now().Add(c.config.GuestCartTTL)); err != nil {
+		log.Printf("unable to extend guest cart %s, err : %s", guestCart.ID, err)
+	}
+	return models.CartOwner{GuestCartID: guestCart.ID}, true, nil
+}
+
+// newGuestCart creates a guest cart and returns its token, which is only ever handed
+// to the client
+func (c *Cart) newGuestCart() (models.CartOwner, string, error) {
+	token, err := utils.NewToken()
+	if err != nil {
+		return models.CartOwner{}, "", err
+	}
+
+	guestCart := models.GuestCart{
+		ID:          utils.NewID(),
+		TokenHash:   utils.HashToken(token),
+		ExpiresDate: time.Now().Add(c.config.GuestCartTTL),
+		CreatedDate: time.Now(),
+	}
+	if err := dao.CreateGuestCart(&guestCart); err != nil {
+		return models.CartOwner{}, "", err
+	}
+	return models.CartOwner{GuestCartID: guestCart.ID}, token, nil
+}
+
+// AddToCart handles adding a product to a cart. Adding a product that is already in
+// the cart increases its quantity. Guests without a cart get a new one, its token is
+// returned in the X-Cart-Token header and the cart_token field.
+func (c *Cart) AddToCart(w http.ResponseWriter, r *http.Request) {
+	var request models.AddToCartRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ProductID == "" {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	if request.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
 
-	cart.ID = utils.NewID()
-	cart.CreatedDate = time.Now()
+	owner, found, err := c.cartOwner(r)
+	if err != nil {
+		log.Printf("unable to fetch cart, err : %s", err)
+		http.Error(w, "Unable to add product to cart", http.StatusInternalServerError)
+		return
+	}
 
+	inCart := 0
+	if found {
+		if inCart, err = dao.GetCartQuantity(owner, request.ProductID); err != nil {
+			log.Printf("unable to fetch cart, err : %s", err)
+			http.Error(w, "Unable to add product to cart", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := checkCartStock(request.ProductID, inCart+request.Quantity); err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	var token string
+	if !found {
+		if owner, token, err = c.newGuestCart(); err != nil {
+			log.Printf("unable to create guest cart, err : %s", err)
+			http.Error(w, "Unable to add product to cart", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(cartTokenHeader, token)
+	}
+
+	cart := models.Cart{
+		ID:          utils.NewID(),
+		UserID:      owner.UserID,
+		GuestCartID: owner.GuestCartID,
+		ProductID:   request.ProductID,
+		Quantity:    request.Quantity,
+		CreatedDate: time.Now(),
+	}
 	if err := dao.AddProductToCart(&cart); err != nil {
 		log.Printf("unable to add product to cart : %s", err)
 		http.Error(w, "Unable to add product to cart", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(cart)
+	writeCart(w, owner, token)
 }
 
-// GetCart handles fetching the cart for a user
-func GetCartItems(w http.ResponseWriter, r *http.Request) {
+// UpdateCartItem handles setting the quantity of a product in the cart, zero removes it
+func (c *Cart) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["userID"]
 
-	var cartItems models.CartItems
-	cartItems.UserID = userID
+	var request models.CartItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if request.Quantity < 0 {
+		http.Error(w, "quantity must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	owner, ok := c.existingCart(w, r)
+	if !ok {
+		return
+	}
+
+	if request.Quantity == 0 {
+		if err := dao.RemoveCartItem(database.DB, owner, vars["productID"]); err != nil && !errors.Is(err, models.ErrCartItemNotFound) {
+			log.Printf("unable to remove product %s from cart, err : %s", vars["productID"], err)
+			http.Error(w, "Unable to update cart", http.StatusInternalServerError)
+			return
+		}
+		writeCart(w, owner, "")
+		return
+	}
+
+	if err := checkCartStock(vars["productID"], request.Quantity); err != nil {
+		writeCartError(w, err)
+		return
+	}
 
-	items, err := dao.GetCartItems(database.DB, userID)
+	now := time.Now()
+	cart := models.Cart{
+		ID:          utils.NewID(),
+		UserID:      owner.UserID,
+		GuestCartID: owner.GuestCartID,
+		ProductID:   vars["productID"],
+		Quantity:    request.Quantity,
+		CreatedDate: now,
+		UpdatedDate: now,
+	}
+	if err := dao.SetCartItemQuantity(database.DB, &cart); err != nil {
+		log.Printf("unable to update cart, err : %s", err)
+		http.Error(w, "Unable to update cart", http.StatusInternalServerError)
+		return
+	}
+
+	writeCart(w, owner, "")
+}
+
+// RemoveCartItem handles removing a product from the cart
+func (c *Cart) RemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	owner, ok := c.existingCart(w, r)
+	if !ok {
+		return
+	}
+
+	if err := dao.RemoveCartItem(database.DB, owner, vars["productID"]); err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	writeCart(w, owner, "")
+}
+
+// ClearCart handles removing every item from the cart
+func (c *Cart) ClearCart(w http.ResponseWriter, r *http.Request) {
+	owner, found, err := c.cartOwner(r)
+	if err == nil && found {
+		err = dao.ClearCart(owner)
+	}
+	if err != nil {
+		log.Printf("unable to clear cart, err : %s", err)
+		http.Error(w, "Unable to clear cart", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCart handles fetching the cart of the caller, guests without a cart get an empty one
+func (c *Cart) GetCartItems(w http.ResponseWriter, r *http.Request) {
+	owner, found, err := c.cartOwner(r)
+	if err != nil {
+		log.Printf("unable to fetch cart, err : %s", err)
+		http.Error(w, "Unable to fetch cart", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		json.NewEncoder(w).Encode(models.NewCartResponse("", 0, nil))
+		return
+	}
+
+	writeCart(w, owner, "")
+}
+
+// existingCart returns the cart of the request, writing an error when there is none
+func (c *Cart) existingCart(w http.ResponseWriter, r *http.Request) (models.CartOwner, bool) {
+	owner, found, err := c.cartOwner(r)
+	if err != nil {
+		log.Printf("unable to fetch cart, err : %s", err)
+		http.Error(w, "Unable to update cart", http.StatusInternalServerError)
+		return owner, false
+	}
+	if !found {
+		http.Error(w, "Cart not found or expired", http.StatusNotFound)
+		return owner, false
+	}
+	return owner, true
+}
+
+// writeCart encodes a cart, token is set only for guest carts created by the request
+func writeCart(w http.ResponseWriter, owner models.CartOwner, token string) {
+	var items []*models.ProductDetails
+	var err error
+	if owner.IsGuest() {
+		items, err = dao.GetGuestCartItems(database.DB, owner.GuestCartID)
+	} else {
+		items, err = dao.GetCartItems(database.DB, owner.UserID)
+	}
 	if err != nil {
-		log.Printf("unable to fetch cart items of userID : %s, err : %s", userID, err)
+		log.Printf("unable to fetch cart items, err : %s", err)
 		http.Error(w, "Unable to fetch cart", http.StatusInternalServerError)
 		return
 	}
 
-	cartItems.Products = items
+	version, err := dao.GetCartVersion(database.DB, owner)
+	if err != nil {
+		log.Printf("unable to fetch cart version, err : %s", err)
+		http.Error(w, "Unable to fetch cart", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.NewCartResponse(owner.UserID, version, items)
+	response.CartToken = token
+	json.NewEncoder(w).Encode(response)
+}
 
-	json.NewEncoder(w).Encode(cartItems)
+// checkCartStock checks that quantity units of a product are available. Stock held
+// by unpaid orders does not count, the cart itself reserves nothing.
+func checkCartStock(productID string, quantity int) error {
+	available, err := dao.GetAvailableStock(productID)
+	if err != nil {
+		return err
+	}
+	if quantity > available {
+		return fmt.Errorf("%w: only %d left", models.ErrInsufficientStock, max(available, 0))
+	}
+	return nil
+}
+
+// writeCartError maps the errors of cart updates to responses
+func writeCartError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrProductNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrCartItemNotFound):
+		http.Error(w, "Product is not in the cart", http.StatusNotFound)
+	case errors.Is(err, models.ErrInsufficientStock):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("unable to update cart, err : %s", err)
+		http.Error(w, "Unable to update cart", http.StatusInternalServerError)
+	}
 }
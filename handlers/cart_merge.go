@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// mergeGuestCart moves the guest cart named by the X-Cart-Token header of a login or
+// registration into the user's cart. Failures are logged, they never fail the login.
+func (u *User) mergeGuestCart(r *http.Request, userID string) {
+	token := r.Header.Get(cartTokenHeader)
+	if token == "" {
+		return
+	}
+
+	merged, err := mergeGuestCart(utils.HashToken(token), userID, u.config.Cart.MergeStrategy)
+	if err != nil {
+		log.Printf("unable to merge guest cart into cart of user %s, err : %s", userID, err)
+		return
+	}
+	if merged > 0 {
+		log.Printf("merged %d guest cart items into cart of user %s", merged, userID)
+	}
+}
+
+// mergeGuestCart combines every line of a guest cart with the user's line for the same
+// product according to strategy, capped at the stock available, then deletes the
+// guest cart. It returns the number of lines merged.
+func mergeGuestCart(tokenHash, userID, strategy string) (int, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	guestCart, err := dao.GetGuestCartForUpdate(tx, tokenHash)
+	if err != nil {
+		if errors.Is(err, models.ErrGuestCartNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	guestLines, err := dao.GetCartLines(tx, models.CartOwner{GuestCartID: guestCart.ID})
+	if err != nil {
+		return 0, err
+	}
+	userLines, err := dao.GetCartLines(tx, models.CartOwner{UserID: userID})
+	if err != nil {
+		return 0, err
+	}
+	existing := make(map[string]*models.Cart, len(userLines))
+	for _, line := range userLines {
+		existing[line.ProductID] = line
+	}
+
+	merged := 0
+	now := time.Now()
+	for _, guestLine := range guestLines {
+		available, err := dao.GetAvailableStock(guestLine.ProductID)
+		if errors.Is(err, models.ErrProductNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		quantity := guestLine.Quantity
+		if userLine, ok := existing[guestLine.ProductID]; ok {
+			switch {
+			case strategy == models.CartMergeLatest && userLine.UpdatedDate.After(guestLine.UpdatedDate):
+				quantity = userLine.Quantity
+			case strategy != models.CartMergeLatest:
+				quantity += userLine.Quantity
+			}
+			if quantity == userLine.Quantity {
+				continue
+			}
+		}
+		// Never put more in the cart than can be bought, but leave existing lines alone
+		// when the product sold out
+		quantity = min(quantity, available)
+		if quantity <= 0 {
+			continue
+		}
+
+		line := models.Cart{
+			ID:          utils.NewID(),
+			UserID:      userID,
+			ProductID:   guestLine.ProductID,
+			Quantity:    quantity,
+			CreatedDate: now,
+			UpdatedDate: now,
+		}
+		if err := dao.SetCartItemQuantity(tx, &line); err != nil {
+			return 0, err
+		}
+		merged++
+	}
+
+	if err := dao.DeleteGuestCart(tx, guestCart.ID); err != nil {
+		return 0, err
+	}
+	return merged, tx.Commit()
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RestoreCart handles the restore link of an abandoned cart reminder. The items the
+// reminder listed are put back in the user's cart as far as they are in stock; lines
+// already holding as many units are left alone. The link is not tied to a session, so
+// it works from any device until it expires.
+func (c *Cart) RestoreCart(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	reminder, err := dao.GetCartReminderByRestoreToken(utils.HashToken(request.Token))
+	if errors.Is(err, models.ErrCartReminderNotFound) {
+		http.Error(w, "Invalid or expired link", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	items, err := dao.GetCartReminderItems(reminder)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	owner := models.CartOwner{UserID: reminder.UserID}
+	tx, err := database.DB.Begin()
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	now := time.Now()
+	for _, item := range items {
+		quantity, err := restoredQuantity(owner, item)
+		if err != nil {
+			tx.Rollback()
+			writeCartError(w, err)
+			return
+		}
+		if quantity == 0 {
+			continue
+		}
+
+		item.ID = utils.NewID()
+		item.Quantity = quantity
+		item.CreatedDate, item.UpdatedDate = now, now
+		if err := dao.SetCartItemQuantity(tx, item); err != nil {
+			tx.Rollback()
+			writeCartError(w, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	writeCart(w, owner, "")
+}
+
+// restoredQuantity returns the quantity a cart line gets back from a reminder, zero
+// when the line is to be left as it is
+func restoredQuantity(owner models.CartOwner, item *models.Cart) (int, error) {
+	inCart, err := dao.GetCartQuantity(owner, item.ProductID)
+	if err != nil || inCart >= item.Quantity {
+		return 0, err
+	}
+
+	available, err := dao.GetAvailableStock(item.ProductID)
+	if errors.Is(err, models.ErrProductNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	quantity := min(item.Quantity, available)
+	if quantity <= inCart {
+		return 0, nil
+	}
+	return quantity, nil
+}
+
+// UnsubscribeCartReminders handles the unsubscribe link of an abandoned cart reminder.
+// It needs no session and can be used any number of times; signed in users can also
+// switch reminders on and off with PATCH /users/me.
+func UnsubscribeCartReminders(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	reminder, err := dao.GetCartReminderByUnsubscribeToken(utils.HashToken(request.Token))
+	if errors.Is(err, models.ErrCartReminderNotFound) {
+		http.Error(w, "Invalid link", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("unable to fetch cart reminder, err : %s", err)
+		http.Error(w, "Unable to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.SetCartRemindersOptOut(reminder.UserID, true); err != nil {
+		log.Printf("unable to opt user %s out of cart reminders, err : %s", reminder.UserID, err)
+		http.Error(w, "Unable to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce/database/dao"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"math"
+	"time"
+)
+
+// cartChanges compares the cart lines with the current catalogue and returns what
+// moved since the customer last saw the cart
+func cartChanges(lines []*models.CheckoutLine) []*models.CartChange {
+	changes := []*models.CartChange{}
+	for _, line := range lines {
+		if line.Discontinued {
+			changes = append(changes, &models.CartChange{
+				ProductID:         line.ProductID,
+				Name:              line.Name,
+				Change:            models.CartChangeDiscontinued,
+				RequestedQuantity: line.Quantity,
+			})
+			continue
+		}
+
+		if line.Price != line.PriceAtAdd {
+			changes = append(changes, &models.CartChange{
+				ProductID:         line.ProductID,
+				Name:              line.Name,
+				Change:            models.CartChangePriceChanged,
+				PreviousPrice:     line.PriceAtAdd,
+				Price:             line.Price,
+				RequestedQuantity: line.Quantity,
+				AvailableQuantity: max(line.Available, 0),
+			})
+		}
+		if line.Quantity > line.Available {
+			changes = append(changes, &models.CartChange{
+				ProductID:         line.ProductID,
+				Name:              line.Name,
+				Change:            models.CartChangeInsufficientStock,
+				Price:             line.Price,
+				RequestedQuantity: line.Quantity,
+				AvailableQuantity: max(line.Available, 0),
+			})
+		}
+	}
+	return changes
+}
+
+// checkoutTotal is the price of the cart at current prices, discontinued lines excluded
+func checkoutTotal(lines []*models.CheckoutLine) float32 {
+	var total float32
+	for _, line := range lines {
+		if !line.Discontinued {
+			total += line.Price * float32(line.Quantity)
+		}
+	}
+	return total
+}
+
+// sameAmount compares prices to the cent
+func sameAmount(a, b float32) bool {
+	return math.Round(float64(a)*100) == math.Round(float64(b)*100)
+}
+
+// refreshCart brings a user's cart in line with the catalogue after checkout found
+// changes: discontinued lines are removed, quantities are capped at the available
+// stock and every line takes the current price. It returns the cart as refreshed.
+func refreshCart(tx *sql.Tx, userID string, lines []*models.CheckoutLine) ([]*models.CheckoutLine, error) {
+	owner := models.CartOwner{UserID: userID}
+	now := time.Now()
+
+	refreshed := []*models.CheckoutLine{}
+	for _, line := range lines {
+		quantity := min(line.Quantity, line.Available)
+		if line.Discontinued || quantity <= 0 {
+			if err := dao.RemoveCartItem(tx, owner, line.ProductID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if quantity == line.Quantity && line.Price == line.PriceAtAdd {
+			refreshed = append(refreshed, line)
+			continue
+		}
+
+		err := dao.SetCartItemQuantity(tx, &models.Cart{
+			ID:          utils.NewID(),
+			UserID:      userID,
+			ProductID:   line.ProductID,
+			Quantity:    quantity,
+			CreatedDate: now,
+			UpdatedDate: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		updated := *line
+		updated.Quantity = quantity
+		updated.PriceAtAdd = line.Price
+		refreshed = append(refreshed, &updated)
+	}
+	return refreshed, nil
+}
+
+// rejectCheckout refreshes the cart and commits, so that the customer can review the
+// changes and check out again with the returned cart version
+func rejectCheckout(tx *sql.Tx, userID string, lines []*models.CheckoutLine, response *models.CheckoutConflictResponse) error {
+	refreshed, err := refreshCart(tx, userID, lines)
+	if err != nil {
+		return err
+	}
+
+	if response.CartVersion, err = dao.GetCartVersion(tx, models.CartOwner{UserID: userID}); err != nil {
+		return err
+	}
+	response.Total = checkoutTotal(refreshed)
+
+	return tx.Commit()
+}
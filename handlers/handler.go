@@ -1,5 +1,15 @@
 package handlers
 
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
 type Handler struct {
 	payment *Payment
 }
@@ -7,3 +17,31 @@ type Handler struct {
 func NewHandle(payment *Payment) *Handler {
 	return &Handler{payment: payment}
 }
+
+// parseLimit reads the page size of a list endpoint, capped at maxPageSize
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
+
+// parseDate accepts either a full RFC 3339 timestamp or a plain 2006-01-02 date
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"ecommerce/utils"
+	"encoding/json"
+	"net/http"
+)
+
+// JWKS handles publishing the public keys tokens issued by Login can be verified with
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(utils.JWTKeys.JWKS())
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/kafka"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoginConfig controls how failed logins are throttled
+type LoginConfig struct {
+	MaxAccountFailures   int           // failures before an account is locked
+	MaxIPFailures        int           // failures before a client address is locked
+	LockoutDuration      time.Duration // how long a lock lasts
+	BackoffAfterFailures int           // failures allowed before backoff starts
+	BackoffBase          time.Duration // first backoff delay, doubled on each further failure
+	FailureWindow        time.Duration // failures older than this no longer count
+	TrustForwardedFor    bool          // read the client address from X-Forwarded-For
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// clientIP returns the address of the caller
+func (c *LoginConfig) clientIP(r *http.Request) string {
+	if c.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// blockedUntil returns when the next login attempt for a key is allowed, either
+// because the key is locked or because of the exponential backoff between failures.
+// Failures last recorded more than FailureWindow ago are ignored.
+func (c *LoginConfig) blockedUntil(attempt *models.LoginAttempt) time.Time {
+	var until time.Time
+	if attempt == nil {
+		return until
+	}
+
+	if attempt.LockedUntil != nil {
+		until = *attempt.LockedUntil
+	}
+
+	stale := attempt.LastFailedDate.Before(time.Now().Add(-c.FailureWindow))
+	if excess := attempt.Failures - c.BackoffAfterFailures; excess > 0 && !stale {
+		delay := time.Duration(float64(c.BackoffBase) * math.Pow(2, float64(excess-1)))
+		if delay > c.LockoutDuration {
+			delay = c.LockoutDuration
+		}
+		if backoff := attempt.LastFailedDate.Add(delay); backoff.After(until) {
+			until = backoff
+		}
+	}
+	return until
+}
+
+// loginRetryAfter returns how long the caller has to wait before trying to log in
+// again, zero when the attempt may go ahead
+func (u *User) loginRetryAfter(keys ...string) time.Duration {
+	var wait time.Duration
+	for _, key := range keys {
+		attempt, err := dao.GetLoginAttempt(key)
+		if err != nil {
+			log.Printf("unable to fetch login attempts of %s, err : %s", key, err)
+			continue
+		}
+
+		if remaining := time.Until(u.config.Login.blockedUntil(attempt)); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed login for the account and the client address,
+// locking either once its threshold is reached. user is nil for unknown emails.
+func (u *User) recordLoginFailure(email, ip string, user *models.User) {
+	login := u.config.Login
+
+	attempt, err := dao.RecordLoginFailure(accountAttemptKey(email), login.FailureWindow)
+	if err != nil {
+		log.Printf("unable to record failed login, err : %s", err)
+	} else if attempt.Failures >= login.MaxAccountFailures {
+		u.lockAccount(attempt.Key, ip, user)
+	}
+
+	attempt, err = dao.RecordLoginFailure(ipAttemptKey(ip), login.FailureWindow)
+	if err != nil {
+		log.Printf("unable to record failed login, err : %s", err)
+	} else if attempt.Failures >= login.MaxIPFailures {
+		if err := dao.LockLoginAttempt(attempt.Key, time.Now().Add(login.LockoutDuration)); err != nil {
+			log.Printf("unable to lock %s, err : %s", attempt.Key, err)
+			return
+		}
+		u.audit("", models.AuditEventIPLocked, "too many failed logins from address", ip)
+	}
+}
+
+func (u *User) lockAccount(key, ip string, user *models.User) {
+	if err := dao.LockLoginAttempt(key, time.Now().Add(u.config.Login.LockoutDuration)); err != nil {
+		log.Printf("unable to lock %s, err : %s", key, err)
+		return
+	}
+
+	if user == nil {
+		// Unknown emails are locked too so that lockouts do not reveal which accounts exist
+		return
+	}
+
+	u.audit(user.ID, models.AuditEventAccountLocked, "too many failed logins", ip)
+
+	link, err := u.newUserTokenLink(user.ID, models.TokenPurposeAccountUnlock, u.config.Login.LockoutDuration, "/unlock-account")
+	if err != nil {
+		log.Printf("unable to create unlock token for user %s, err : %s", user.ID, err)
+		return
+	}
+
+	u.producer.PublishUserNotification(&kafka.UserNotificationEvent{
+		Event:     kafka.EventAccountLocked,
+		UserID:    user.ID,
+		FirstName: user.FirstName,
+		Email:     user.Email,
+		Link:      link,
+	})
+}
+
+func (u *User) audit(userID, event, detail, ip string) {
+	err := dao.InsertSecurityAuditLog(&models.SecurityAuditLog{
+		ID:          utils.NewID(),
+		UserID:      userID,
+		Event:       event,
+		Detail:      detail,
+		IPAddress:   ip,
+		CreatedDate: time.Now(),
+	})
+	if err != nil {
+		log.Printf("unable to write %s audit log, err : %s", event, err)
+	}
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+// UnlockAccount handles lifting an account lock with the token from the lockout email
+func (u *User) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to unlock account", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := dao.ConsumeUserToken(tx, utils.HashToken(request.Token), models.TokenPurposeAccountUnlock)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit account unlock, err : %s", err)
+		http.Error(w, "Unable to unlock account", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := dao.GetUserByID(token.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := dao.ClearLoginAttempts(accountAttemptKey(user.Email)); err != nil {
+		log.Printf("unable to unlock user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to unlock account", http.StatusInternalServerError)
+		return
+	}
+
+	u.audit(user.ID, models.AuditEventAccountUnlocked, "unlocked from email link", u.config.Login.clientIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
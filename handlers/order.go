@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"database/sql"
 	"ecommerce/database"
 	"ecommerce/database/dao"
+	"ecommerce/gateway"
+	"ecommerce/kafka"
+	"ecommerce/middleware"
 	"ecommerce/models"
 	"ecommerce/utils"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -13,16 +19,58 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// CreateOrder handles creating an order
-func CreateOrder(w http.ResponseWriter, r *http.Request) {
-	var order models.Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+type Order struct {
+	producer             *kafka.Producer
+	inventoryProducer    *kafka.Producer
+	gateway              gateway.PaymentGateway
+	requireVerifiedEmail bool // only users with a verified email may check out
+}
+
+func NewOrder(producer, inventoryProducer *kafka.Producer, paymentGateway gateway.PaymentGateway, requireVerifiedEmail bool) *Order {
+	return &Order{producer: producer, inventoryProducer: inventoryProducer, gateway: paymentGateway, requireVerifiedEmail: requireVerifiedEmail}
+}
+
+// CreateOrder handles creating an order from the cart. The cart is revalidated
+// first, see CreateOrderRequest and models.CheckoutConflictResponse.
+func (o *Order) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	var request models.CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	order.ID = utils.NewID()
-	order.CreatedDate = time.Now()
+	order := models.Order{
+		ID:          utils.NewID(),
+		UserID:      middleware.UserID(r),
+		CreatedDate: time.Now(),
+	}
+
+	// The address is copied onto the order, later edits of the entry do not change it
+	var address *models.Address
+	var err error
+	if request.ShippingAddressID != "" {
+		address, err = dao.GetAddress(order.UserID, request.ShippingAddressID)
+	} else {
+		address, err = dao.GetDefaultShippingAddress(order.UserID)
+	}
+	if err != nil {
+		http.Error(w, "A valid shipping address is required", http.StatusBadRequest)
+		return
+	}
+	order.ShippingAddress = &address.PostalAddress
+
+	if o.requireVerifiedEmail {
+		user, err := dao.GetUserByID(order.UserID)
+		if err != nil {
+			log.Printf("unable to fetch user %s, err : %s", order.UserID, err)
+			http.Error(w, "Unable to process order", http.StatusInternalServerError)
+			return
+		}
+		if !user.EmailVerified {
+			http.Error(w, "Please verify your email address before checking out", http.StatusForbidden)
+			return
+		}
+	}
 
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -39,17 +87,54 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	items, err := dao.GetCartItems(tx, order.UserID)
+	lines, err := dao.GetCheckoutLines(tx, order.UserID)
 	if err != nil {
 		log.Printf("unable to fetch order price, err : %s", err)
 		tx.Rollback()
 		http.Error(w, "unable to fetch order price", http.StatusInternalServerError)
 		return
 	}
-	if len(items) == 0 {
-		log.Printf("your cart is empty")
+	if len(lines) == 0 {
 		tx.Rollback()
 		http.Error(w, "your cart is empty", http.StatusBadRequest)
+		return
+	}
+
+	// The customer is only charged what they saw: any change to prices, availability
+	// or the cart itself since then is sent back for confirmation
+	cartVersion, err := dao.GetCartVersion(tx, models.CartOwner{UserID: order.UserID})
+	if err != nil {
+		log.Printf("unable to fetch cart version, err : %s", err)
+		tx.Rollback()
+		http.Error(w, "Unable to process order", http.StatusInternalServerError)
+		return
+	}
+	changes := cartChanges(lines)
+	totalPrice := checkoutTotal(lines)
+	cartChanged := request.CartVersion != nil && *request.CartVersion != cartVersion
+	if len(changes) > 0 || cartChanged || (request.ExpectedTotal != nil && !sameAmount(*request.ExpectedTotal, totalPrice)) {
+		conflict := models.CheckoutConflictResponse{
+			Error:         "Your cart has changed, please review it before placing the order",
+			CartChanged:   cartChanged,
+			ExpectedTotal: request.ExpectedTotal,
+			Changes:       changes,
+		}
+		if err := rejectCheckout(tx, order.UserID, lines, &conflict); err != nil {
+			log.Printf("unable to refresh cart of user %s, err : %s", order.UserID, err)
+			tx.Rollback()
+			http.Error(w, "Unable to process order", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(conflict)
+		return
+	}
+
+	items := make([]*models.ProductDetails, 0, len(lines))
+	for _, line := range lines {
+		items = append(items, &models.ProductDetails{ID: line.ProductID, Name: line.Name, Price: line.Price, Quantity: line.Quantity})
 	}
 
 	for _, item := range items {
@@ -62,13 +147,8 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var totalPrice float32
-	for _, item := range items {
-		totalPrice += float32(item.Price * float32(item.Quantity))
-	}
-
 	order.TotalPrice = totalPrice
-	order.Status = "pending"
+	order.Status = models.OrderStatusPending
 
 	if err := dao.CreateOrder(tx, &order); err != nil {
 		log.Printf("unable to creat order, err : %s", err)
@@ -77,6 +157,21 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = dao.InsertOrderStatusHistory(tx, &models.OrderStatusTransition{
+		ID:          utils.NewID(),
+		OrderID:     order.ID,
+		ToStatus:    order.Status,
+		Actor:       order.UserID,
+		Reason:      "order placed",
+		CreatedDate: order.CreatedDate,
+	})
+	if err != nil {
+		log.Printf("unable to record order status, err : %s", err)
+		tx.Rollback()
+		http.Error(w, "Unable to create order", http.StatusInternalServerError)
+		return
+	}
+
 	for _, item := range items {
 		err := dao.UpdateOrderItems(tx, order.ID, item)
 		if err != nil {
@@ -107,22 +202,263 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	response := map[string]interface{}{
 		"order_id": order.ID,
-		"status":   "pending",
+		"status":   order.Status,
 		"message":  "Order created successfully. Proceed to payment.",
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetOrders handles fetching orders for a user
+// GetOrders handles fetching a page of the authenticated user's orders
 func GetOrders(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["userID"]
+	userID := middleware.UserID(r)
+	query := r.URL.Query()
+
+	filter := models.OrderFilter{
+		Status: query.Get("status"),
+		Cursor: query.Get("cursor"),
+	}
+
+	var err error
+	if filter.Limit, err = parseLimit(query.Get("limit")); err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if filter.From, err = parseDate(query.Get("from")); err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseDate(query.Get("to")); err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+	if len(query.Get("to")) == len("2006-01-02") {
+		// A plain date includes the whole day
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	if filter.Cursor != "" {
+		// The cursor holds the creation date and ID of the last order of the previous page
+		parts, err := utils.DecodeCursor(filter.Cursor, 2)
+		if err == nil {
+			_, err = time.Parse(time.RFC3339Nano, parts[0])
+		}
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
 
-	orders, err := dao.GetOrders(userID)
+	page, err := dao.GetOrders(userID, &filter)
 	if err != nil {
+		log.Printf("unable to fetch orders of user %s, err : %s", userID, err)
 		http.Error(w, "Unable to fetch orders", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(orders)
+	json.NewEncoder(w).Encode(models.NewOrderPageResponse(page))
+}
+
+// GetOrder handles fetching one of the authenticated user's orders with its items
+func GetOrder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+
+	order, err := dao.GetOrderByID(orderID)
+	if err != nil || !middleware.CanAccess(r, order.UserID) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	order.Items, err = dao.GetOrderItems(orderID)
+	if err != nil {
+		log.Printf("unable to fetch items of order %s, err : %s", orderID, err)
+		http.Error(w, "Unable to fetch order", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(models.NewOrderResponse(order))
+}
+
+// UpdateOrderStatus handles moving an order through the order state machine. Orders
+// are canceled and refunded through closeOrder; paid is reserved for payment webhooks,
+// which also take the stock of the order.
+func (o *Order) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+
+	var update models.OrderStatusUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil || update.Status == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if update.Status == models.OrderStatusPaid {
+		http.Error(w, "Orders are marked paid by the payment gateway", http.StatusConflict)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to update order status", http.StatusInternalServerError)
+		return
+	}
+
+	var transition *models.OrderStatusTransition
+	var payment *models.PaymentDetails
+	var restocked []*models.StockChange
+	if update.Status == models.OrderStatusCanceled || update.Status == models.OrderStatusRefunded {
+		transition, payment, restocked, err = closeOrder(tx, orderID, update.Status, middleware.UserID(r), update.Reason)
+	} else {
+		transition, err = dao.TransitionOrderStatus(tx, orderID, update.Status, middleware.UserID(r), update.Reason)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Printf("unable to update status of order %s, err : %s", orderID, err)
+		if errors.Is(err, models.ErrInvalidOrderTransition) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Unable to update order status", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit order status, err : %s", err)
+		http.Error(w, "Unable to update order status", http.StatusInternalServerError)
+		return
+	}
+
+	o.producer.PublishOrderStatus(transition)
+	o.inventoryProducer.PublishStockChanges(restocked)
+	if payment != nil {
+		o.refund(payment, "order "+update.Status)
+	}
+
+	json.NewEncoder(w).Encode(transition)
+}
+
+// closeOrder cancels or refunds an order inside tx and undoes what the order did to
+// the stock. Unpaid orders release their reservation and close their payment attempts.
+// Paid orders that never shipped are restocked; returned goods are not, they are
+// checked before going back on sale. The payment of a paid order is marked
+// refund_pending and returned so the caller can refund it once tx is committed, along
+// with the products whose stock came back, to be announced after commit as well.
+func closeOrder(tx *sql.Tx, orderID, status, actor, reason string) (*models.OrderStatusTransition, *models.PaymentDetails, []*models.StockChange, error) {
+	transition, err := dao.TransitionOrderStatus(tx, orderID, status, actor, reason)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if transition.FromStatus == models.OrderStatusPending {
+		released, err := dao.RestoreReservedStock(tx, orderID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return transition, nil, released, dao.ExpirePendingPayments(tx, orderID)
+	}
+
+	var restocked []*models.StockChange
+	if transition.FromStatus != models.OrderStatusReturned {
+		if restocked, err = dao.RestoreStockForOrder(tx, orderID); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	payment, err := dao.GetSuccessfulPayment(tx, orderID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := dao.MarkPaymentRefundPending(tx, payment.ID); err != nil {
+		return nil, nil, nil, err
+	}
+	return transition, payment, restocked, nil
+}
+
+// refund returns the payment of an order closed by closeOrder. No row locks are held
+// during the gateway call; a failed refund leaves the payment refund_pending and is
+// retried by jobs.RefundRetrier.
+func (o *Order) refund(payment *models.PaymentDetails, reason string) {
+	if err := refundPayment(o.gateway, payment, reason); err != nil {
+		log.Printf("unable to refund payment %s of order %s, err : %s", payment.ID, payment.OrderID, err)
+	}
+}
+
+// CancelOrder handles a customer canceling their own order. Pending orders release
+// their reserved stock, paid orders that have not shipped yet are restocked and refunded.
+// The refund is issued once the cancellation is committed, see closeOrder.
+func (o *Order) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+	userID := middleware.UserID(r)
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if request.Reason == "" {
+		request.Reason = "canceled by customer"
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to cancel order", http.StatusInternalServerError)
+		return
+	}
+
+	order, err := dao.GetOrderForUpdate(tx, orderID)
+	if err != nil || order.UserID != userID {
+		tx.Rollback()
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	transition, payment, restocked, err := closeOrder(tx, orderID, models.OrderStatusCanceled, userID, request.Reason)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("unable to cancel order %s, err : %s", orderID, err)
+		if errors.Is(err, models.ErrInvalidOrderTransition) {
+			http.Error(w, "Order can no longer be canceled", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Unable to cancel order", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit cancellation of order %s, err : %s", orderID, err)
+		http.Error(w, "Unable to cancel order", http.StatusInternalServerError)
+		return
+	}
+
+	o.producer.PublishOrderStatus(transition)
+	o.inventoryProducer.PublishStockChanges(restocked)
+	if payment != nil {
+		o.refund(payment, request.Reason)
+	}
+
+	json.NewEncoder(w).Encode(transition)
+}
+
+// GetOrderStatusHistory handles fetching every status change of an order
+func GetOrderStatusHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+
+	order, err := dao.GetOrderByID(orderID)
+	if err != nil || !middleware.CanAccess(r, order.UserID) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	history, err := dao.GetOrderStatusHistory(orderID)
+	if err != nil {
+		log.Printf("unable to fetch status history of order %s, err : %s", orderID, err)
+		http.Error(w, "Unable to fetch order status history", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(history)
 }
@@ -1,25 +1,36 @@
 package handlers
 
 import (
+	"database/sql"
 	"ecommerce/database"
 	"ecommerce/database/dao"
+	"ecommerce/gateway"
 	"ecommerce/kafka"
+	"ecommerce/middleware"
 	"ecommerce/models"
+	"ecommerce/utils"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
 )
 
 type Payment struct {
-	producer *kafka.Producer
+	producer          *kafka.Producer
+	inventoryProducer *kafka.Producer
+	gateway           gateway.PaymentGateway
+	verifier          *utils.WebhookVerifier
 }
 
-func NewPayment(producer *kafka.Producer) *Payment {
-	return &Payment{producer: producer}
+func NewPayment(producer, inventoryProducer *kafka.Producer, paymentGateway gateway.PaymentGateway, verifier *utils.WebhookVerifier) *Payment {
+	return &Payment{producer: producer, inventoryProducer: inventoryProducer, gateway: paymentGateway, verifier: verifier}
 }
 
-func InitiatePayment(w http.ResponseWriter, r *http.Request) {
+func (p *Payment) InitiatePayment(w http.ResponseWriter, r *http.Request) {
 	var paymentRequest models.PaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&paymentRequest); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
@@ -28,44 +39,84 @@ func InitiatePayment(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the order
 	order, err := dao.GetOrderByID(paymentRequest.OrderID)
-	if err != nil || order.Status != "pending" {
+	if err != nil || order.UserID != middleware.UserID(r) || order.Status != models.OrderStatusPending {
 		http.Error(w, "Invalid or non-pending order", http.StatusBadRequest)
 		return
 	}
 
-	// Simulate payment link generation (replace with actual payment gateway integration)
-	paymentLink := fmt.Sprintf("https://paymentgateway.com/pay?order_id=%s&amount=%.2f",
-		order.ID, order.TotalPrice)
+	payment := models.PaymentDetails{
+		ID:            utils.NewID(),
+		OrderID:       order.ID,
+		Method:        paymentRequest.Method,
+		PaymentStatus: models.PaymentStatusPending,
+		Amount:        order.TotalPrice,
+		CreatedDate:   time.Now(),
+		UpdatedDate:   time.Now(),
+	}
+	if err := dao.CreatePayment(&payment); err != nil {
+		log.Printf("unable to save payment for order %s, err : %s", order.ID, err)
+		http.Error(w, "Unable to initiate payment", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := p.gateway.CreateCheckoutSession(&gateway.CheckoutRequest{
+		PaymentID: payment.ID,
+		OrderID:   order.ID,
+		Amount:    order.TotalPrice,
+		Method:    payment.Method,
+	})
+	if err != nil {
+		log.Printf("unable to create checkout session for order %s, err : %s", order.ID, err)
+		if err := dao.UpdatePaymentStatus(payment.ID, models.PaymentStatusFailed); err != nil {
+			log.Printf("unable to mark payment %s as failed, err : %s", payment.ID, err)
+		}
+		http.Error(w, "Unable to initiate payment", http.StatusBadGateway)
+		return
+	}
 
-	// Optionally, save the payment initiation details in the database
-	// err = dao.SavePaymentDetails(order.ID, paymentRequest.Method, "pending")
-	// if err != nil {
-	// 	http.Error(w, "Unable to initiate payment", http.StatusInternalServerError)
-	// 	return
-	// }
+	if err := dao.UpdatePaymentSession(payment.ID, session.ID); err != nil {
+		log.Printf("unable to save checkout session of payment %s, err : %s", payment.ID, err)
+		http.Error(w, "Unable to initiate payment", http.StatusInternalServerError)
+		return
+	}
 
 	response := map[string]string{
-		"payment_link": paymentLink,
-		"status":       "pending",
+		"payment_id":   payment.ID,
+		"payment_link": session.CheckoutURL,
+		"status":       payment.PaymentStatus,
 	}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
 func (p *Payment) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	// Verify the webhook request (HMAC signature over timestamp and body)
+	body, err := p.verifier.Verify(r)
+	if err != nil {
+		log.Printf("invalid webhook request, err : %s", err)
+		http.Error(w, "Unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
 	var webhookData models.WebhookData
-	if err := json.NewDecoder(r.Body).Decode(&webhookData); err != nil {
+	if err := json.Unmarshal(body, &webhookData); err != nil {
 		log.Printf("invalid payload, err : %s", err)
 		http.Error(w, "Invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	// // Verify the webhook request (e.g., HMAC signature)
-	// if err := utils.VerifyWebhook(r); err != nil { // Implement VerifyWebhook for your gateway
-	// 	log.Printf("invalid webhook request, err : %s", err)
-	// 	http.Error(w, "Unauthorized request", http.StatusUnauthorized)
-	// 	return
-	// }
+	if webhookData.TransactionID == "" || webhookData.OrderID == "" {
+		log.Printf("webhook without transaction or order id : %+v", webhookData)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	// Unknown statuses are rejected before the delivery is marked processed, so that a
+	// later valid delivery of the same transaction is still applied
+	if webhookData.Status != models.PaymentStatusSuccess && webhookData.Status != models.PaymentStatusFailed {
+		log.Printf("webhook for transaction %s with unknown status %q", webhookData.TransactionID, webhookData.Status)
+		http.Error(w, "Invalid payment status", http.StatusBadRequest)
+		return
+	}
 
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -82,15 +133,65 @@ func (p *Payment) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	// Redelivered webhooks are acknowledged without being applied again
+	firstDelivery, err := dao.MarkWebhookProcessed(tx, &webhookData)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Failed to record webhook, err: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !firstDelivery {
+		tx.Rollback()
+		log.Printf("webhook for transaction %s already processed", webhookData.TransactionID)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Webhook already processed"))
+		return
+	}
+
+	order, err := dao.GetOrderForUpdate(tx, webhookData.OrderID)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Failed to fetch order %s, err: %v", webhookData.OrderID, err)
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	if order.Status != models.OrderStatusPending && webhookData.Status == models.PaymentStatusSuccess {
+		p.refundLatePayment(w, tx, order, &webhookData)
+		return
+	}
+	if order.Status != models.OrderStatusPending {
+		p.recordLateFailure(w, tx, order, &webhookData)
+		return
+	}
+
+	var transition *models.OrderStatusTransition
+	var released []*models.StockChange
 	// Update order and payment status
-	if webhookData.Status == "success" {
-		if err := dao.UpdateOrderStatus(tx, webhookData.OrderID, "completed"); err != nil {
+	if webhookData.Status == models.PaymentStatusSuccess {
+		if math.Abs(float64(webhookData.Amount-order.TotalPrice)) >= 0.01 {
+			tx.Rollback()
+			log.Printf("webhook amount %.2f does not match order %s total %.2f", webhookData.Amount, order.ID, order.TotalPrice)
+			http.Error(w, "Amount does not match order total", http.StatusUnprocessableEntity)
+			return
+		}
+
+		transition, err = dao.TransitionOrderStatus(tx, webhookData.OrderID, models.OrderStatusPaid, models.ActorPaymentGateway, "payment "+webhookData.TransactionID+" succeeded")
+		if err != nil {
 			tx.Rollback()
 			log.Printf("Failed to update order status, err: %v", err)
 			http.Error(w, "Failed to update order status", http.StatusInternalServerError)
 			return
 		}
 
+		if err := dao.UpdatePaymentFromWebhook(tx, &webhookData, models.PaymentStatusSuccess); err != nil {
+			tx.Rollback()
+			log.Printf("Failed to update payment, err: %v", err)
+			http.Error(w, "Failed to update payment", http.StatusInternalServerError)
+			return
+		}
+
 		if err := dao.DeductStockForOrder(tx, webhookData.OrderID); err != nil {
 			tx.Rollback()
 			log.Printf("Failed to deduct stock, err: %v", err)
@@ -98,28 +199,63 @@ func (p *Payment) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		p.producer.PublishOrderStatus(webhookData.OrderID, "order placed")
-		// kafka.PublishOrderStatus(webhookData.OrderID, "order placed")
-		// sendOrderStatusDetails(webhookData.OrderID, "order placed")
-
-	} else if webhookData.Status == "failed" {
-		if err := dao.UpdateOrderStatus(tx, webhookData.OrderID, "canceled"); err != nil {
+	} else if webhookData.Status == models.PaymentStatusFailed {
+		transition, err = dao.TransitionOrderStatus(tx, webhookData.OrderID, models.OrderStatusCanceled, models.ActorPaymentGateway, "payment "+webhookData.TransactionID+" failed")
+		if err != nil {
 			tx.Rollback()
 			log.Printf("Failed to update order status, err: %v", err)
 			http.Error(w, "Failed to update order status", http.StatusInternalServerError)
 			return
 		}
 
-		if err := dao.RestoreReservedStock(tx, webhookData.OrderID); err != nil {
+		if err := dao.UpdatePaymentFromWebhook(tx, &webhookData, models.PaymentStatusFailed); err != nil {
+			tx.Rollback()
+			log.Printf("Failed to update payment, err: %v", err)
+			http.Error(w, "Failed to update payment", http.StatusInternalServerError)
+			return
+		}
+
+		released, err = dao.RestoreReservedStock(tx, webhookData.OrderID)
+		if err != nil {
 			tx.Rollback()
 			log.Printf("Failed to restore stock, err: %v", err)
 			http.Error(w, "Failed to restore stock", http.StatusInternalServerError)
 			return
 		}
+	} else {
+		tx.Rollback()
+		log.Printf("webhook for transaction %s with unknown status %q", webhookData.TransactionID, webhookData.Status)
+		http.Error(w, "Invalid payment status", http.StatusBadRequest)
+		return
+	}
 
-		// sendOrderStatusDetails(webhookData.OrderID, "order failed")
-		p.producer.PublishOrderStatus(webhookData.OrderID, "order failed")
+	if err := tx.Commit(); err != nil {
+		log.Printf("Transaction commit failed, err: %v", err)
+		http.Error(w, "Transaction commit failed", http.StatusInternalServerError)
+		return
+	}
+
+	// Published only after commit so a rolled back webhook never notifies the customer
+	if transition != nil {
+		p.producer.PublishOrderStatus(transition)
+	}
+	p.inventoryProducer.PublishStockChanges(released)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Webhook processed successfully"))
+}
 
+// recordLateFailure acknowledges a failed payment for an order that no longer awaits
+// payment, typically one the sweeper expired before the gateway gave up. Nothing was
+// captured, the attempt is only recorded so that the gateway stops redelivering it.
+func (p *Payment) recordLateFailure(w http.ResponseWriter, tx *sql.Tx, order *models.Order, webhookData *models.WebhookData) {
+	log.Printf("payment %s failed for order %s in status %s, recording it", webhookData.TransactionID, order.ID, order.Status)
+
+	if err := dao.UpdatePaymentFromWebhook(tx, webhookData, models.PaymentStatusFailed); err != nil {
+		tx.Rollback()
+		log.Printf("Failed to update payment, err: %v", err)
+		http.Error(w, "Failed to update payment", http.StatusInternalServerError)
+		return
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -132,4 +268,103 @@ func (p *Payment) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Webhook processed successfully"))
 }
 
+// refundLatePayment handles money captured for an order that no longer awaits payment,
+// e.g. one that expired or was canceled while the customer was at the gateway, or a
+// second payment of a paid order. The payment is recorded as refund_pending with the
+// webhook and refunded after commit. A refund that fails stays refund_pending and is
+// retried by jobs.RefundRetrier.
+func (p *Payment) refundLatePayment(w http.ResponseWriter, tx *sql.Tx, order *models.Order, webhookData *models.WebhookData) {
+	log.Printf("payment %s captured for order %s in status %s, refunding it", webhookData.TransactionID, order.ID, order.Status)
+
+	if err := dao.UpdatePaymentFromWebhook(tx, webhookData, models.PaymentStatusRefundPending); err != nil {
+		tx.Rollback()
+		log.Printf("Failed to update payment, err: %v", err)
+		http.Error(w, "Failed to update payment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Transaction commit failed, err: %v", err)
+		http.Error(w, "Transaction commit failed", http.StatusInternalServerError)
+		return
+	}
+
+	payment, err := dao.GetPaymentByTransactionID(webhookData.TransactionID)
+	if err == nil {
+		err = refundPayment(p.gateway, payment, "order "+order.ID+" was "+order.Status+" when the payment arrived")
+	}
+	if err != nil {
+		log.Printf("unable to refund payment %s of order %s, left for reconciliation, err : %s", webhookData.TransactionID, order.ID, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Webhook processed, payment is refunded"))
+}
+
+// refundPayment returns a captured payment through the gateway and records the refund.
+// The payment ID is the idempotency key, so a refund retried after its result could
+// not be recorded is not paid out twice.
+func refundPayment(paymentGateway gateway.PaymentGateway, payment *models.PaymentDetails, reason string) error {
+	refund, err := paymentGateway.Refund(&gateway.RefundRequest{
+		TransactionID:  payment.TransactionId,
+		Amount:         payment.Amount,
+		Reason:         reason,
+		IdempotencyKey: payment.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := dao.MarkPaymentRefunded(database.DB, payment.ID, refund.ID); err != nil {
+		return fmt.Errorf("refund %s issued but payment %s not updated: %w", refund.ID, payment.ID, err)
+	}
+	return nil
+}
+
 // func (p *Payment) sendOrderStatusDetails(webhookData.OrderID, "order place")
+
+// GetPayment handles fetching a single payment record
+func GetPayment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	paymentID := vars["id"]
+
+	payment, err := dao.GetPaymentByID(paymentID)
+	if err != nil {
+		log.Printf("unable to fetch payment %s, err : %s", paymentID, err)
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	order, err := dao.GetOrderByID(payment.OrderID)
+	if err != nil || !middleware.CanAccess(r, order.UserID) {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(models.NewPaymentResponse(payment))
+}
+
+// GetOrderPayments handles fetching every payment attempt of an order
+func GetOrderPayments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+
+	order, err := dao.GetOrderByID(orderID)
+	if err != nil || !middleware.CanAccess(r, order.UserID) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	payments, err := dao.GetPaymentsByOrderID(orderID)
+	if err != nil {
+		log.Printf("unable to fetch payments of order %s, err : %s", orderID, err)
+		http.Error(w, "Unable to fetch payments", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]*models.PaymentResponse, 0, len(payments))
+	for _, payment := range payments {
+		response = append(response, models.NewPaymentResponse(payment))
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -2,43 +2,313 @@ package handlers
 
 import (
 	"ecommerce/database/dao"
+	"ecommerce/kafka"
 	"ecommerce/models"
+	"ecommerce/search"
 	"ecommerce/utils"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gorilla/mux"
 )
 
+type Product struct {
+	producer *kafka.Producer
+	index    *search.Index
+}
+
+// NewProduct returns the product handlers. Catalogue changes are published through
+// producer, which keeps the search index of every instance up to date.
+func NewProduct(producer *kafka.Producer, index *search.Index) *Product {
+	return &Product{
+		producer: producer,
+		index:    index,
+	}
+}
+
+// validateProduct checks the catalogue fields of a product
+func validateProduct(product *models.Product) error {
+	product.Name = strings.TrimSpace(product.Name)
+	switch {
+	case product.Name == "":
+		return errors.New("name is required")
+	case product.Price < 0:
+		return errors.New("price must not be negative")
+	case product.Stock < 0:
+		return errors.New("stock must not be negative")
+	}
+	return nil
+}
+
+// validateProductPatch checks the fields given in a patch, the others were validated
+// when they were written
+func validateProductPatch(patch *models.ProductPatch) error {
+	if patch.Name != nil {
+		name := strings.TrimSpace(*patch.Name)
+		patch.Name = &name
+	}
+	switch {
+	case patch.Name != nil && *patch.Name == "":
+		return errors.New("name is required")
+	case patch.Price != nil && *patch.Price < 0:
+		return errors.New("price must not be negative")
+	case patch.Stock != nil && *patch.Stock < 0:
+		return errors.New("stock must not be negative")
+	}
+	return nil
+}
+
+// writeProduct encodes a product with its version as the ETag, which PUT, PATCH and
+// DELETE expect back in If-Match
+func writeProduct(w http.ResponseWriter, product *models.Product, status int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(product.Version)))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.NewProductResponse(product))
+}
+
+// ifMatchVersion reads the product version from the If-Match header. "*" matches any
+// version and is returned as 0.
+func ifMatchVersion(r *http.Request) (int, bool) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "*" {
+		return 0, true
+	}
+
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err := strconv.Atoi(value)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// writeProductError maps the errors of product updates to responses
+func writeProductError(w http.ResponseWriter, productID string, err error) {
+	switch {
+	case errors.Is(err, models.ErrProductNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrProductVersionConflict):
+		http.Error(w, "Product was modified by someone else, fetch it again and retry", http.StatusPreconditionFailed)
+	case errors.Is(err, models.ErrStockBelowReserved):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("unable to update product %s, err : %s", productID, err)
+		http.Error(w, "Unable to update product", http.StatusInternalServerError)
+	}
+}
+
 // CreateProduct handles creating a new product
-func CreateProduct(w http.ResponseWriter, r *http.Request) {
-	var product models.Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+func (p *Product) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	var request models.ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	product.ID = utils.NewID()
-	product.CreatedDate = time.Now()
-	product.UpdatedDate = time.Now()
+	product := models.Product{
+		ID:          utils.NewID(),
+		Name:        request.Name,
+		Description: request.Description,
+		Price:       request.Price,
+		Stock:       request.Stock,
+		Category:    request.Category,
+		CreatedDate: time.Now(),
+		UpdatedDate: time.Now(),
+	}
+	if err := validateProduct(&product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if err := dao.CreateProduct(&product); err != nil {
 		log.Printf("unable to create product : %s", err)
 		http.Error(w, "Unable to create product", http.StatusInternalServerError)
 		return
 	}
+	p.producer.PublishProductEvent(kafka.EventProductCreated, &product, nil)
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(product)
+	writeProduct(w, &product, http.StatusCreated)
 }
 
-// GetProducts handles fetching all products
+// GetProducts handles searching the catalogue. Supported query parameters are q,
+// category, min_price, max_price, in_stock, sort, cursor and limit.
 func GetProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := dao.GetProducts()
+	query := r.URL.Query()
+
+	filter := models.ProductFilter{
+		Query:    strings.TrimSpace(query.Get("q")),
+		Category: query.Get("category"),
+		Sort:     query.Get("sort"),
+		Cursor:   query.Get("cursor"),
+	}
+	if filter.Sort == "" {
+		filter.Sort = models.ProductSortNewest
+	}
+
+	var err error
+	if filter.Limit, err = parseLimit(query.Get("limit")); err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if filter.MinPrice, err = parsePrice(query.Get("min_price")); err != nil {
+		http.Error(w, "Invalid min_price", http.StatusBadRequest)
+		return
+	}
+	if filter.MaxPrice, err = parsePrice(query.Get("max_price")); err != nil {
+		http.Error(w, "Invalid max_price", http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("in_stock"); value != "" {
+		if filter.InStock, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid in_stock", http.StatusBadRequest)
+			return
+		}
+	}
+	switch filter.Sort {
+	case models.ProductSortNewest, models.ProductSortPriceAsc, models.ProductSortPriceDesc, models.ProductSortName:
+	default:
+		http.Error(w, "Invalid sort, use newest, price_asc, price_desc or name", http.StatusBadRequest)
+		return
+	}
+	page, err := dao.GetProducts(&filter)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
+		log.Printf("unable to fetch products, err : %s", err)
 		http.Error(w, "Unable to fetch products", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(products)
+	json.NewEncoder(w).Encode(models.NewProductPageResponse(page))
+}
+
+// parsePrice reads an optional price bound
+func parsePrice(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 {
+		return nil, strconv.ErrSyntax
+	}
+	return &price, nil
+}
+
+// GetProduct handles fetching a single product
+func GetProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	product, err := dao.GetProductByID(vars["id"])
+	if err != nil {
+		if !errors.Is(err, models.ErrProductNotFound) {
+			log.Printf("unable to fetch product %s, err : %s", vars["id"], err)
+		}
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	writeProduct(w, product, http.StatusOK)
+}
+
+// UpdateProduct handles replacing a product. The If-Match header must carry the
+// ETag the product was read with.
+func (p *Product) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, ok := ifMatchVersion(r)
+	if !ok {
+		http.Error(w, "If-Match header with the product ETag is required", http.StatusPreconditionRequired)
+		return
+	}
+
+	var request models.ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	product := models.Product{
+		ID:          vars["id"],
+		Name:        request.Name,
+		Description: request.Description,
+		Price:       request.Price,
+		Stock:       request.Stock,
+		Category:    request.Category,
+	}
+	if err := validateProduct(&product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	previous, err := dao.UpdateProduct(&product, version)
+	if err != nil {
+		writeProductError(w, product.ID, err)
+		return
+	}
+	p.producer.PublishProductEvent(kafka.EventProductUpdated, &product, previous)
+
+	writeProduct(w, &product, http.StatusOK)
+}
+
+// PatchProduct handles changing some fields of a product. The If-Match header must
+// carry the ETag the product was read with.
+func (p *Product) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, ok := ifMatchVersion(r)
+	if !ok {
+		http.Error(w, "If-Match header with the product ETag is required", http.StatusPreconditionRequired)
+		return
+	}
+
+	var patch models.ProductPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateProductPatch(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	product, previous, err := dao.PatchProduct(vars["id"], &patch, version)
+	if err != nil {
+		writeProductError(w, vars["id"], err)
+		return
+	}
+	p.producer.PublishProductEvent(kafka.EventProductUpdated, product, previous)
+
+	writeProduct(w, product, http.StatusOK)
+}
+
+// DeleteProduct handles removing a product from the catalogue. Products are soft
+// deleted so that past orders still show them. If-Match is checked when given.
+func (p *Product) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version := 0
+	if r.Header.Get("If-Match") != "" {
+		var ok bool
+		if version, ok = ifMatchVersion(r); !ok {
+			http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := dao.DeleteProduct(vars["id"], version); err != nil {
+		writeProductError(w, vars["id"], err)
+		return
+	}
+	p.producer.PublishProductDeleted(vars["id"])
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/kafka"
+	"ecommerce/middleware"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// UpdateCurrentUser handles changing the caller's name, email address and whether
+// abandoned cart reminders are sent. A new email address only replaces the current
+// one once it has been verified with the link sent to it, and changing it requires
+// the current password. The whole request is validated before anything is saved.
+func (u *User) UpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		FirstName       *string `json:"first_name"`
+		LastName        *string `json:"last_name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+		CartReminders   *bool   `json:"cart_reminders"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := dao.GetUserByID(middleware.UserID(r))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	changeName := request.FirstName != nil || request.LastName != nil
+	firstName, lastName := user.FirstName, user.LastName
+	if request.FirstName != nil {
+		firstName = strings.TrimSpace(*request.FirstName)
+	}
+	if request.LastName != nil {
+		lastName = strings.TrimSpace(*request.LastName)
+	}
+	if changeName && firstName == "" {
+		http.Error(w, "First name must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	var email string
+	if request.Email != nil && !strings.EqualFold(strings.TrimSpace(*request.Email), user.Email) {
+		email = strings.TrimSpace(*request.Email)
+		if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		if !utils.CheckPasswordHash(request.CurrentPassword, user.Password) {
+			http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+			return
+		}
+
+		taken, err := dao.IsEmailTaken(email, user.ID)
+		if err != nil {
+			log.Printf("unable to check email of user %s, err : %s", user.ID, err)
+			http.Error(w, "Unable to update user", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			http.Error(w, "Email already in use", http.StatusConflict)
+			return
+		}
+	}
+
+	if changeName {
+		if err := dao.UpdateUserName(user.ID, firstName, lastName); err != nil {
+			log.Printf("unable to update name of user %s, err : %s", user.ID, err)
+			http.Error(w, "Unable to update user", http.StatusInternalServerError)
+			return
+		}
+		user.FirstName, user.LastName = firstName, lastName
+	}
+
+	if request.CartReminders != nil {
+		if err := dao.SetCartRemindersOptOut(user.ID, !*request.CartReminders); err != nil {
+			log.Printf("unable to update cart reminders of user %s, err : %s", user.ID, err)
+			http.Error(w, "Unable to update user", http.StatusInternalServerError)
+			return
+		}
+		user.CartRemindersOptOut = !*request.CartReminders
+	}
+
+	if email != "" {
+		if err := dao.SetPendingEmail(user.ID, email); err != nil {
+			log.Printf("unable to set pending email of user %s, err : %s", user.ID, err)
+			http.Error(w, "Unable to update user", http.StatusInternalServerError)
+			return
+		}
+		user.PendingEmail = email
+
+		if err := u.sendEmailChangeVerification(user); err != nil {
+			log.Printf("unable to send email change verification to user %s, err : %s", user.ID, err)
+			http.Error(w, "Unable to send verification email", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(models.NewUserResponse(user))
+}
+
+// sendEmailChangeVerification sends the confirmation link to the pending address
+func (u *User) sendEmailChangeVerification(user *models.User) error {
+	link, err := u.newUserTokenLink(user.ID, models.TokenPurposeEmailChange, u.config.EmailVerificationTTL, "/confirm-email")
+	if err != nil {
+		return err
+	}
+
+	return u.producer.PublishUserNotification(&kafka.UserNotificationEvent{
+		Event:     kafka.EventEmailVerification,
+		UserID:    user.ID,
+		FirstName: user.FirstName,
+		Email:     user.PendingEmail,
+		Link:      link,
+	})
+}
+
+// ConfirmEmailChange handles switching to the pending email address with a token
+// from the link UpdateCurrentUser sent to it
+func (u *User) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to change email", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := dao.ConsumeUserToken(tx, utils.HashToken(request.Token), models.TokenPurposeEmailChange)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Fails when the pending address was taken by another account in the meantime,
+	// which the unique email column rejects
+	if err := dao.ConfirmPendingEmail(tx, token.UserID); err != nil {
+		tx.Rollback()
+		log.Printf("unable to change email of user %s, err : %s", token.UserID, err)
+		http.Error(w, "Unable to change email", http.StatusConflict)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit email change, err : %s", err)
+		http.Error(w, "Unable to change email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword handles replacing the caller's password. Every other session of the
+// user is revoked afterwards.
+func (u *User) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if len(request.NewPassword) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	principal := middleware.CurrentPrincipal(r)
+	user, err := dao.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !utils.CheckPasswordHash(request.CurrentPassword, user.Password) {
+		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(request.NewPassword)
+	if err != nil {
+		log.Printf("unable to hash password, err : %s", err)
+		http.Error(w, "Unable to change password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.UpdatePassword(tx, user.ID, hashedPassword); err != nil {
+		tx.Rollback()
+		log.Printf("unable to update password of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit password change, err : %s", err)
+		http.Error(w, "Unable to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.RevokeOtherSessions(user.ID, principal.SessionID); err != nil {
+		log.Printf("unable to revoke sessions of user %s, err : %s", user.ID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteCurrentUser handles closing the caller's account. The account and the shipping
+// addresses of its orders are anonymised so that the orders remain for bookkeeping
+// without personal details.
+// Accounts with orders that are still being paid or fulfilled cannot be closed.
+func (u *User) DeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	principal := middleware.CurrentPrincipal(r)
+	user, err := dao.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !utils.CheckPasswordHash(request.Password, user.Password) {
+		http.Error(w, "Password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	open, err := dao.HasOpenOrders(user.ID)
+	if err != nil {
+		log.Printf("unable to check orders of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to delete account", http.StatusInternalServerError)
+		return
+	}
+	if open {
+		http.Error(w, "Account has open orders, cancel them or wait until they are delivered", http.StatusConflict)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	if err := deleteAccount(tx, user.ID); err != nil {
+		tx.Rollback()
+		log.Printf("unable to delete user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit account deletion, err : %s", err)
+		http.Error(w, "Unable to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.RevokeUserSessions(user.ID); err != nil {
+		log.Printf("unable to revoke sessions of user %s, err : %s", user.ID, err)
+	}
+	if err := dao.ClearLoginAttempts(accountAttemptKey(user.Email)); err != nil {
+		log.Printf("unable to clear failed logins of user %s, err : %s", user.ID, err)
+	}
+
+	u.audit(user.ID, models.AuditEventAccountDeleted, "account closed by user", u.config.Login.clientIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAccount removes everything tied to a user that is not needed for bookkeeping
+// and anonymises the user row
+func deleteAccount(tx *sql.Tx, userID string) error {
+	if err := dao.DeleteCartItems(tx, userID); err != nil {
+		return err
+	}
+	if err := dao.DeleteTwoFactor(tx, userID); err != nil {
+		return err
+	}
+	if err := dao.InvalidateUserTokens(tx, userID); err != nil {
+		return err
+	}
+	if err := deleteUserAddresses(tx, userID); err != nil {
+		return err
+	}
+	if err := dao.DeleteUserWishlists(tx, userID); err != nil {
+		return err
+	}
+	if err := dao.DeleteUserSavedItems(tx, userID); err != nil {
+		return err
+	}
+	if err := dao.DeleteUserCartReminders(tx, userID); err != nil {
+		return err
+	}
+	return dao.DeleteUser(tx, userID)
+}
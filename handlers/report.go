@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"ecommerce/database/dao"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// GetSalesReport handles fetching order counts and value per status. The period
+// defaults to the last 30 days.
+func GetSalesReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	from, err := parseDate(query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	to, err := parseDate(query.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	} else if len(query.Get("to")) == len("2006-01-02") {
+		// A plain date includes the whole day
+		to = to.AddDate(0, 0, 1)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -30)
+	}
+
+	report, err := dao.GetSalesReport(from, to)
+	if err != nil {
+		log.Printf("unable to build sales report, err : %s", err)
+		http.Error(w, "Unable to build sales report", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/middleware"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GetSavedItems handles listing the products the caller saved for later
+func (c *Cart) GetSavedItems(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+
+	items, err := dao.GetSavedItems(userID)
+	if err != nil {
+		log.Printf("unable to fetch saved items of user %s, err : %s", userID, err)
+		http.Error(w, "Unable to fetch saved items", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(items)
+}
+
+// SaveForLater handles moving a line of the caller's cart to the saved for later list,
+// quantity included
+func (c *Cart) SaveForLater(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	owner := models.CartOwner{UserID: middleware.UserID(r)}
+
+	quantity, err := dao.GetCartQuantity(owner, vars["productID"])
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if quantity == 0 {
+		writeCartError(w, models.ErrCartItemNotFound)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if err := dao.SaveForLater(tx, owner.UserID, vars["productID"], quantity, time.Now()); err != nil {
+		tx.Rollback()
+		writeCartError(w, err)
+		return
+	}
+	if err := dao.RemoveCartItem(tx, owner, vars["productID"]); err != nil {
+		tx.Rollback()
+		writeCartError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	writeCart(w, owner, "")
+}
+
+// MoveToCart handles moving a saved item back into the caller's cart. Its quantity
+// is added to any already in the cart and has to be in stock.
+func (c *Cart) MoveToCart(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	owner := models.CartOwner{UserID: middleware.UserID(r)}
+
+	saved, err := dao.GetSavedQuantity(owner.UserID, vars["productID"])
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if saved == 0 {
+		http.Error(w, "Product is not saved for later", http.StatusNotFound)
+		return
+	}
+
+	inCart, err := dao.GetCartQuantity(owner, vars["productID"])
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if err := checkCartStock(vars["productID"], inCart+saved); err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	now := time.Now()
+	err = dao.SetCartItemQuantity(tx, &models.Cart{
+		ID:          utils.NewID(),
+		UserID:      owner.UserID,
+		ProductID:   vars["productID"],
+		Quantity:    inCart + saved,
+		CreatedDate: now,
+		UpdatedDate: now,
+	})
+	if err != nil {
+		tx.Rollback()
+		writeCartError(w, err)
+		return
+	}
+	if err := dao.RemoveSavedItem(tx, owner.UserID, vars["productID"]); err != nil {
+		tx.Rollback()
+		writeCartError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	writeCart(w, owner, "")
+}
+
+// RemoveSavedItem handles removing a product from the caller's saved for later list
+func (c *Cart) RemoveSavedItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := dao.RemoveSavedItem(database.DB, middleware.UserID(r), vars["productID"])
+	if errors.Is(err, models.ErrSavedItemNotFound) {
+		http.Error(w, "Product is not saved for later", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("unable to remove saved item, err : %s", err)
+		http.Error(w, "Unable to remove saved item", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"ecommerce/models"
+	"ecommerce/search"
+	"ecommerce/utils"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Number of entries returned by GET /products/suggest unless limit asks for fewer
+const maxSuggestions = 10
+
+// SearchProducts handles relevance ranked search of the catalogue with facet counts.
+// Supported query parameters are q, category, min_price, max_price, in_stock, cursor
+// and limit. Results come from the in-memory index, which follows product changes
+// within moments and stock levels within the index refresh interval.
+func (p *Product) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	searchQuery := search.Query{
+		Text:     query.Get("q"),
+		Category: query.Get("category"),
+	}
+
+	var err error
+	if searchQuery.Limit, err = parseLimit(query.Get("limit")); err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if searchQuery.MinPrice, err = parsePrice(query.Get("min_price")); err != nil {
+		http.Error(w, "Invalid min_price", http.StatusBadRequest)
+		return
+	}
+	if searchQuery.MaxPrice, err = parsePrice(query.Get("max_price")); err != nil {
+		http.Error(w, "Invalid max_price", http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("in_stock"); value != "" {
+		if searchQuery.InStock, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid in_stock", http.StatusBadRequest)
+			return
+		}
+	}
+	// Results are ranked by score, so the cursor is the offset of the next page
+	if cursor := query.Get("cursor"); cursor != "" {
+		parts, err := utils.DecodeCursor(cursor, 1)
+		if err == nil {
+			searchQuery.Offset, err = strconv.Atoi(parts[0])
+		}
+		if err != nil || searchQuery.Offset < 0 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := p.index.Search(&searchQuery)
+
+	response := models.ProductSearchResponse{
+		Products: make([]*models.ProductResponse, 0, len(result.Products)),
+		Total:    result.Total,
+		Facets:   result.Facets,
+	}
+	for _, product := range result.Products {
+		response.Products = append(response.Products, models.NewProductResponse(product))
+	}
+	if next := searchQuery.Offset + len(result.Products); next < result.Total {
+		response.NextCursor = utils.EncodeCursor(strconv.Itoa(next))
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// SuggestProducts handles autocomplete, q is matched as typed so its last word may be
+// incomplete
+func (p *Product) SuggestProducts(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := maxSuggestions
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSuggestions)
+	}
+
+	json.NewEncoder(w).Encode(p.index.Suggest(text, limit))
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/middleware"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// startSession opens a login session for the user and issues its first token pair.
+// twoFactor records whether the login completed a second factor.
+func startSession(user *models.User, twoFactor bool) (*models.TokenPair, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		ID:          utils.NewID(),
+		UserID:      user.ID,
+		TwoFactor:   twoFactor,
+		CreatedDate: time.Now(),
+	}
+	if err := dao.CreateSession(tx, &session); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tokens, err := issueTokens(tx, user, &session)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// issueTokens creates an access token and a single use refresh token for a session
+func issueTokens(tx *sql.Tx, user *models.User, session *models.Session) (*models.TokenPair, error) {
+	refreshToken, err := utils.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = dao.CreateRefreshToken(tx, &models.RefreshToken{
+		ID:          utils.NewID(),
+		SessionID:   session.ID,
+		UserID:      user.ID,
+		TokenHash:   utils.HashToken(refreshToken),
+		ExpiresDate: time.Now().Add(utils.RefreshTokenTTL),
+		CreatedDate: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Role, session.ID, session.TwoFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// RefreshToken handles exchanging a refresh token for a new token pair. Refresh
+// tokens are single use; presenting one twice revokes the whole session because it
+// means the token has leaked.
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := dao.GetRefreshTokenForUpdate(tx, utils.HashToken(request.RefreshToken))
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if stored.UsedDate != nil {
+		tx.Rollback()
+		log.Printf("refresh token reuse detected, revoking session %s", stored.SessionID)
+		if err := dao.RevokeSession(stored.SessionID); err != nil {
+			log.Printf("unable to revoke session %s, err : %s", stored.SessionID, err)
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if time.Now().After(stored.ExpiresDate) {
+		tx.Rollback()
+		http.Error(w, "Refresh token expired", http.StatusUnauthorized)
+		return
+	}
+
+	// The role is read again so that promotions and demotions apply on refresh
+	user, err := dao.GetUserByID(stored.UserID)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := dao.GetSession(tx, stored.SessionID)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("unable to fetch session %s, err : %s", stored.SessionID, err)
+		http.Error(w, "Unable to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.MarkRefreshTokenUsed(tx, stored.ID); err != nil {
+		tx.Rollback()
+		log.Printf("unable to consume refresh token, err : %s", err)
+		http.Error(w, "Unable to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := issueTokens(tx, user, session)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("unable to issue tokens, err : %s", err)
+		http.Error(w, "Unable to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit refreshed tokens, err : %s", err)
+		http.Error(w, "Unable to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout handles ending the caller's session
+func Logout(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	if err := dao.RevokeSession(principal.SessionID); err != nil {
+		log.Printf("unable to revoke session %s, err : %s", principal.SessionID, err)
+		http.Error(w, "Unable to logout", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.RevokeToken(principal.TokenID, principal.ExpiresAt); err != nil {
+		log.Printf("unable to revoke token of session %s, err : %s", principal.SessionID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll handles ending every session of the caller, on every device
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	if err := dao.RevokeUserSessions(principal.UserID); err != nil {
+		log.Printf("unable to revoke sessions of user %s, err : %s", principal.UserID, err)
+		http.Error(w, "Unable to logout", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.RevokeToken(principal.TokenID, principal.ExpiresAt); err != nil {
+		log.Printf("unable to revoke token of session %s, err : %s", principal.SessionID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
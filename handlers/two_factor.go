@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/middleware"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+// TwoFactorConfig holds the TOTP settings
+type TwoFactorConfig struct {
+	Issuer        string        // account name shown in authenticator apps
+	EncryptionKey string        // AES key the TOTP secrets are stored with
+	LoginTokenTTL time.Duration // time allowed between the password and the code step
+}
+
+// writeTwoFactorChallenge answers a correct password of a user with two-factor
+// authentication enabled. The returned token is exchanged at LoginTwoFactor.
+func (u *User) writeTwoFactorChallenge(w http.ResponseWriter, user *models.User) {
+	token, err := newUserToken(user.ID, models.TokenPurposeTwoFactorLogin, u.config.TwoFactor.LoginTokenTTL)
+	if err != nil {
+		log.Printf("unable to create two-factor token for user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to login", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&models.TwoFactorChallenge{
+		TwoFactorRequired: true,
+		Token:             token,
+		ExpiresIn:         int(u.config.TwoFactor.LoginTokenTTL.Seconds()),
+	})
+}
+
+// LoginTwoFactor handles the second login step, exchanging the token from Login and
+// a TOTP or recovery code for a token pair. Wrong codes count as failed logins.
+func (u *User) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token        string `json:"two_factor_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" || (request.Code == "" && request.RecoveryCode == "") {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to login", http.StatusInternalServerError)
+		return
+	}
+
+	// The token is only consumed when the transaction commits, so a mistyped code
+	// can be retried until the token expires or the account is locked
+	token, err := dao.ConsumeUserToken(tx, utils.HashToken(request.Token), models.TokenPurposeTwoFactorLogin)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid or expired two-factor token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := dao.GetUserByID(token.UserID)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "Invalid or expired two-factor token", http.StatusUnauthorized)
+		return
+	}
+
+	ip := u.config.Login.clientIP(r)
+	if wait := u.loginRetryAfter(accountAttemptKey(user.Email), ipAttemptKey(ip)); wait > 0 {
+		tx.Rollback()
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	ok, err := u.verifySecondFactor(tx, user.ID, request.Code, request.RecoveryCode)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("unable to verify second factor of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to login", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		tx.Rollback()
+		u.recordLoginFailure(user.Email, ip, user)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit two-factor login, err : %s", err)
+		http.Error(w, "Unable to login", http.StatusInternalServerError)
+		return
+	}
+
+	if request.RecoveryCode != "" {
+		u.audit(user.ID, models.AuditEventRecoveryCode, "recovery code used to log in", ip)
+	}
+
+	// As in Login, the address counter is not cleared
+	if err := dao.ClearLoginAttempts(accountAttemptKey(user.Email)); err != nil {
+		log.Printf("unable to clear failed logins of user %s, err : %s", user.ID, err)
+	}
+
+	tokens, err := startSession(user, true)
+	if err != nil {
+		log.Printf("unable to start session for user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to login", http.StatusInternalServerError)
+		return
+	}
+	u.mergeGuestCart(r, user.ID)
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when one is given, of a
+// user with two-factor authentication enabled and consumes it within tx
+func (u *User) verifySecondFactor(tx *sql.Tx, userID, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return useRecoveryCode(tx, userID, recoveryCode)
+	}
+
+	twoFactor, err := dao.GetTwoFactor(userID)
+	if err != nil || !twoFactor.Enabled() {
+		return false, err
+	}
+
+	secret, err := utils.Decrypt(twoFactor.SecretEncrypted, u.config.TwoFactor.EncryptionKey)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return dao.UseTOTPStep(tx, userID, step)
+}
+
+func useRecoveryCode(tx *sql.Tx, userID, recoveryCode string) (bool, error) {
+	codes, err := dao.GetUnusedRecoveryCodesForUpdate(tx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	recoveryCode = utils.NormalizeRecoveryCode(recoveryCode)
+	for _, code := range codes {
+		if utils.CheckPasswordHash(recoveryCode, code.CodeHash) {
+			return true, dao.MarkRecoveryCodeUsed(tx, code.ID)
+		}
+	}
+	return false, nil
+}
+
+// SetupTwoFactor handles starting TOTP enrollment. The secret is returned once, as is
+// and as an otpauth:// URI; enrollment completes when VerifyTwoFactor accepts a code.
+func (u *User) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := dao.GetUserByID(middleware.UserID(r))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	twoFactor, err := dao.GetTwoFactor(user.ID)
+	if err != nil {
+		log.Printf("unable to fetch two-factor enrollment of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if twoFactor.Enabled() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		log.Printf("unable to generate totp secret, err : %s", err)
+		http.Error(w, "Unable to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	encrypted, err := utils.Encrypt(secret, u.config.TwoFactor.EncryptionKey)
+	if err != nil {
+		log.Printf("unable to encrypt totp secret, err : %s", err)
+		http.Error(w, "Unable to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	err = dao.SaveTwoFactorSecret(&models.TwoFactor{
+		UserID:          user.ID,
+		SecretEncrypted: encrypted,
+		CreatedDate:     time.Now(),
+	})
+	if err != nil {
+		log.Printf("unable to save totp secret of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_url": utils.TOTPURI(u.config.TwoFactor.Issuer, user.Email, secret),
+	})
+}
+
+// VerifyTwoFactor handles confirming enrollment with a first code from the
+// authenticator app. The recovery codes are returned once and only stored hashed.
+func (u *User) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	userID := middleware.UserID(r)
+	twoFactor, err := dao.GetTwoFactor(userID)
+	if err != nil {
+		log.Printf("unable to fetch two-factor enrollment of user %s, err : %s", userID, err)
+		http.Error(w, "Unable to verify two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if twoFactor == nil {
+		http.Error(w, "Two-factor setup has not been started", http.StatusConflict)
+		return
+	}
+	if twoFactor.Enabled() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := utils.Decrypt(twoFactor.SecretEncrypted, u.config.TwoFactor.EncryptionKey)
+	if err != nil {
+		log.Printf("unable to decrypt totp secret of user %s, err : %s", userID, err)
+		http.Error(w, "Unable to verify two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(secret, request.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, recoveryCodes, err := newRecoveryCodes(userID)
+	if err != nil {
+		log.Printf("unable to generate recovery codes, err : %s", err)
+		http.Error(w, "Unable to verify two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to verify two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dao.EnableTwoFactor(tx, userID, step); err != nil {
+		tx.Rollback()
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	if err := dao.ReplaceRecoveryCodes(tx, userID, recoveryCodes); err != nil {
+		tx.Rollback()
+		log.Printf("unable to store recovery codes of user %s, err : %s", userID, err)
+		http.Error(w, "Unable to verify two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit two-factor enrollment, err : %s", err)
+		http.Error(w, "Unable to verify two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	u.audit(userID, models.AuditEventTwoFactorOn, "totp enrolled", u.config.Login.clientIP(r))
+
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTwoFactor handles turning two-factor authentication off. It requires the
+// password and a current TOTP or recovery code.
+func (u *User) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || (request.Code == "" && request.RecoveryCode == "") {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := dao.GetUserByID(middleware.UserID(r))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !utils.CheckPasswordHash(request.Password, user.Password) {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	twoFactor, err := dao.GetTwoFactor(user.ID)
+	if err != nil {
+		log.Printf("unable to fetch two-factor enrollment of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if !twoFactor.Enabled() {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("unable to start transaction, err : %s", err)
+		http.Error(w, "Unable to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	ok, err := u.verifySecondFactor(tx, user.ID, request.Code, request.RecoveryCode)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("unable to verify second factor of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		tx.Rollback()
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	if err := dao.DeleteTwoFactor(tx, user.ID); err != nil {
+		tx.Rollback()
+		log.Printf("unable to disable two-factor authentication of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("unable to commit two-factor removal, err : %s", err)
+		http.Error(w, "Unable to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	u.audit(user.ID, models.AuditEventTwoFactorOff, "totp removed", u.config.Login.clientIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes and their hashed records
+func newRecoveryCodes(userID string) ([]string, []*models.RecoveryCode, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]*models.RecoveryCode, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.NewRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hash, err := utils.HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code)
+		records = append(records, &models.RecoveryCode{
+			ID:          utils.NewID(),
+			UserID:      userID,
+			CodeHash:    hash,
+			CreatedDate: time.Now(),
+		})
+	}
+	return codes, records, nil
+}
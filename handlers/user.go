@@ -3,6 +3,7 @@ package handlers
 import (
 	"ecommerce/database/dao"
 	"ecommerce/kafka"
+	"ecommerce/middleware"
 	"ecommerce/models" // A utility package for encryption
 	"ecommerce/utils"
 	"encoding/json"
@@ -15,29 +16,49 @@ import (
 
 type User struct {
 	producer *kafka.Producer
+	config   *UserConfig
 }
 
-func NewUser(producer *kafka.Producer) *User {
+// UserConfig holds the account settings used by the user handlers
+type UserConfig struct {
+	AppBaseURL           string // frontend address used in email links
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+	Login                LoginConfig
+	TwoFactor            TwoFactorConfig
+	Cart                 CartConfig
+}
+
+func NewUser(producer *kafka.Producer, config *UserConfig) *User {
 	return &User{
 		producer: producer,
+		config:   config,
 	}
 }
 
-// CreateUser handles user registration
+// CreateUser handles user registration. A guest cart sent in X-Cart-Token becomes
+// the new user's cart.
 func (u *User) CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var request models.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	user.ID = utils.NewID()
-	user.CreatedDate = time.Now()
-	user.UpdatedDate = time.Now()
-
 	// Hashing the password
-	hashedPassword, _ := utils.HashPassword(user.Password)
-	user.Password = hashedPassword
+	hashedPassword, _ := utils.HashPassword(request.Password)
+
+	user := models.User{
+		ID:        utils.NewID(),
+		FirstName: request.FirstName,
+		LastName:  request.LastName,
+		Email:     request.Email,
+		Password:  hashedPassword,
+		// Admins are promoted in the database, never through self registration
+		Role:        models.RoleCustomer,
+		CreatedDate: time.Now(),
+		UpdatedDate: time.Now(),
+	}
 
 	err := dao.CreateUser(&user)
 	if err != nil {
@@ -45,6 +66,8 @@ func (u *User) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	u.mergeGuestCart(r, user.ID)
+
 	userInfo := models.User{
 		ID:        user.ID,
 		FirstName: user.FirstName,
@@ -52,12 +75,18 @@ func (u *User) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	u.producer.PublishUserAccountCreated(&userInfo)
 
+	if err := u.sendEmailVerification(&user); err != nil {
+		log.Printf("unable to send email verification to user %s, err : %s", user.ID, err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(models.NewUserResponse(&user))
 }
 
-// Login handles user login
-func Login(w http.ResponseWriter, r *http.Request) {
+// Login handles user login. Failed attempts are throttled per account and per client
+// address, see login_guard.go. A guest cart sent in X-Cart-Token is merged into the
+// user's cart.
+func (u *User) Login(w http.ResponseWriter, r *http.Request) {
 	var loginData struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -68,34 +97,73 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := u.config.Login.clientIP(r)
+	if wait := u.loginRetryAfter(accountAttemptKey(loginData.Email), ipAttemptKey(ip)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
 	// Validate user credentials
 	user, err := dao.GetUserByEmail(loginData.Email)
 	if err != nil || !utils.CheckPasswordHash(loginData.Password, user.Password) {
-		log.Printf("db errror is %s", err)
+		u.recordLoginFailure(loginData.Email, ip, user)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate JWT Token for authentication
-	token, err := utils.GenerateJWT(user.ID)
+	// Users with two-factor authentication get a token for the code step instead. Their
+	// failed logins are only cleared by LoginTwoFactor, clearing them here would let a
+	// stolen password reset the counter of wrong codes.
+	twoFactor, err := dao.GetTwoFactor(user.ID)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("unable to fetch two-factor enrollment of user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to login", http.StatusInternalServerError)
+		return
+	}
+	if twoFactor.Enabled() {
+		u.writeTwoFactorChallenge(w, user)
+		return
 	}
 
-	if len(token) == 0 {
-		log.Fatal("invalid token!")
+	// Only the account is forgiven, the address counter keeps running so that a client
+	// cannot reset it by logging into an account it controls
+	if err := dao.ClearLoginAttempts(accountAttemptKey(loginData.Email)); err != nil {
+		log.Printf("unable to clear failed logins of user %s, err : %s", user.ID, err)
 	}
 
+	// Generate JWT Token for authentication
+	tokens, err := startSession(user, false)
+	if err != nil {
+		log.Printf("unable to start session for user %s, err : %s", user.ID, err)
+		http.Error(w, "Unable to login", http.StatusInternalServerError)
+		return
+	}
+	u.mergeGuestCart(r, user.ID)
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(tokens)
 }
 
-// GetUser handles fetching user information
+// GetUser handles fetching user information, users may only read their own account
+// unless they are admins
 func GetUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
-	log.Printf("user id is : %s", id)
 
+	if !middleware.CanAccess(r, id) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	writeUser(w, id)
+}
+
+// GetCurrentUser handles fetching the authenticated user
+func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	writeUser(w, middleware.UserID(r))
+}
+
+func writeUser(w http.ResponseWriter, id string) {
 	user, err := dao.GetUserByID(id)
 	if err != nil {
 		log.Printf("db error is : %s", err)
@@ -103,5 +171,5 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(models.NewUserResponse(user))
 }
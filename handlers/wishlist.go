@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"ecommerce/database/dao"
+	"ecommerce/middleware"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxWishlistNameLength = 100
+
+type Wishlist struct {
+	appBaseURL string // frontend address share links point to
+}
+
+func NewWishlist(appBaseURL string) *Wishlist {
+	return &Wishlist{appBaseURL: appBaseURL}
+}
+
+// shareURL returns the public link of a shared wishlist, empty for private lists
+func (wl *Wishlist) shareURL(wishlist *models.Wishlist) string {
+	if wishlist.ShareToken == "" {
+		return ""
+	}
+	return wl.appBaseURL + "/wishlists/shared/" + wishlist.ShareToken
+}
+
+// decodeWishlistRequest reads and validates the body of a wishlist request
+func decodeWishlistRequest(r *http.Request) (*models.WishlistRequest, error) {
+	var request models.WishlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errors.New("Invalid input")
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	switch {
+	case request.Name == "":
+		return nil, errors.New("name is required")
+	case len(request.Name) > maxWishlistNameLength:
+		return nil, errors.New("name is too long")
+	}
+	return &request, nil
+}
+
+// writeWishlistError maps the errors of wishlist requests to responses
+func writeWishlistError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrWishlistNotFound):
+		http.Error(w, "Wishlist not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrWishlistItemNotFound):
+		http.Error(w, "Product is not in the wishlist", http.StatusNotFound)
+	case errors.Is(err, models.ErrProductNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	default:
+		log.Printf("unable to update wishlist, err : %s", err)
+		http.Error(w, "Unable to update wishlist", http.StatusInternalServerError)
+	}
+}
+
+// GetWishlists handles listing the caller's wishlists
+func (wl *Wishlist) GetWishlists(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+
+	wishlists, err := dao.GetWishlists(userID)
+	if err != nil {
+		log.Printf("unable to fetch wishlists of user %s, err : %s", userID, err)
+		http.Error(w, "Unable to fetch wishlists", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]*models.WishlistResponse, 0, len(wishlists))
+	for _, wishlist := range wishlists {
+		response = append(response, models.NewWishlistResponse(wishlist, wl.shareURL(wishlist), nil))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateWishlist handles creating a named wishlist for the caller
+func (wl *Wishlist) CreateWishlist(w http.ResponseWriter, r *http.Request) {
+	request, err := decodeWishlistRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wishlist := models.Wishlist{
+		ID:          utils.NewID(),
+		UserID:      middleware.UserID(r),
+		Name:        request.Name,
+		CreatedDate: time.Now(),
+		UpdatedDate: time.Now(),
+	}
+	if err := dao.CreateWishlist(&wishlist); err != nil {
+		log.Printf("unable to create wishlist for user %s, err : %s", wishlist.UserID, err)
+		http.Error(w, "Unable to create wishlist", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.NewWishlistResponse(&wishlist, "", []*models.WishlistItem{}))
+}
+
+// GetWishlist handles fetching one of the caller's wishlists with its items
+func (wl *Wishlist) GetWishlist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	wishlist, err := dao.GetWishlist(middleware.UserID(r), vars["id"])
+	if err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	wl.writeWishlist(w, wishlist, http.StatusOK)
+}
+
+func (wl *Wishlist) writeWishlist(w http.ResponseWriter, wishlist *models.Wishlist, status int) {
+	items, err := dao.GetWishlistItems(wishlist.ID)
+	if err != nil {
+		log.Printf("unable to fetch items of wishlist %s, err : %s", wishlist.ID, err)
+		http.Error(w, "Unable to fetch wishlist", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.NewWishlistResponse(wishlist, wl.shareURL(wishlist), items))
+}
+
+// RenameWishlist handles changing the name of one of the caller's wishlists
+func (wl *Wishlist) RenameWishlist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	request, err := decodeWishlistRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wishlist, err := dao.GetWishlist(middleware.UserID(r), vars["id"])
+	if err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	wishlist.Name = request.Name
+	wishlist.UpdatedDate = time.Now()
+	if err := dao.UpdateWishlist(wishlist); err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	wl.writeWishlist(w, wishlist, http.StatusOK)
+}
+
+// DeleteWishlist handles removing one of the caller's wishlists
+func (wl *Wishlist) DeleteWishlist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := dao.DeleteWishlist(middleware.UserID(r), vars["id"]); err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddWishlistItem handles adding a product to one of the caller's wishlists. Users
+// are emailed when a product on any of their wishlists is back in stock or cheaper.
+func (wl *Wishlist) AddWishlistItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var request models.WishlistItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ProductID == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	wishlist, err := dao.GetWishlist(middleware.UserID(r), vars["id"])
+	if err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	if err := dao.AddWishlistItem(wishlist.ID, request.ProductID, time.Now()); err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	wl.writeWishlist(w, wishlist, http.StatusOK)
+}
+
+// RemoveWishlistItem handles removing a product from one of the caller's wishlists
+func (wl *Wishlist) RemoveWishlistItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	wishlist, err := dao.GetWishlist(middleware.UserID(r), vars["id"])
+	if err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	if err := dao.RemoveWishlistItem(wishlist.ID, vars["productID"]); err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	wl.writeWishlist(w, wishlist, http.StatusOK)
+}
+
+// ShareWishlist handles making one of the caller's wishlists viewable by anyone with
+// its link. Sharing a list that is already shared returns the existing link.
+func (wl *Wishlist) ShareWishlist(w http.ResponseWriter, r *http.Request) {
+	wl.setShared(w, r, true)
+}
+
+// UnshareWishlist handles making a shared wishlist private again, its link stops
+// working and sharing it again creates a new one
+func (wl *Wishlist) UnshareWishlist(w http.ResponseWriter, r *http.Request) {
+	wl.setShared(w, r, false)
+}
+
+func (wl *Wishlist) setShared(w http.ResponseWriter, r *http.Request, shared bool) {
+	vars := mux.Vars(r)
+
+	wishlist, err := dao.GetWishlist(middleware.UserID(r), vars["id"])
+	if err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	if shared == (wishlist.ShareToken != "") {
+		wl.writeWishlist(w, wishlist, http.StatusOK)
+		return
+	}
+
+	wishlist.ShareToken = ""
+	if shared {
+		if wishlist.ShareToken, err = utils.NewToken(); err != nil {
+			writeWishlistError(w, err)
+			return
+		}
+	}
+	wishlist.UpdatedDate = time.Now()
+	if err := dao.UpdateWishlist(wishlist); err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	wl.writeWishlist(w, wishlist, http.StatusOK)
+}
+
+// GetSharedWishlist handles viewing a shared wishlist through its link, no login needed
+func (wl *Wishlist) GetSharedWishlist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	wishlist, err := dao.GetSharedWishlist(vars["token"])
+	if err != nil {
+		writeWishlistError(w, err)
+		return
+	}
+
+	items, err := dao.GetWishlistItems(wishlist.ID)
+	if err != nil {
+		log.Printf("unable to fetch items of wishlist %s, err : %s", wishlist.ID, err)
+		http.Error(w, "Unable to fetch wishlist", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&models.SharedWishlistResponse{Name: wishlist.Name, Items: items})
+}
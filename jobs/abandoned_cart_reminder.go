@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/kafka"
+	"ecommerce/models"
+	"ecommerce/utils"
+	"log"
+	"net/url"
+	"time"
+)
+
+// CartReminderConfig holds the settings of the abandoned cart reminder job
+type CartReminderConfig struct {
+	AppBaseURL   string          // frontend address used in email links
+	IdleAfter    []time.Duration // how long a cart must be left alone before each reminder
+	MaxReminders int             // reminders per cart version, at most len(IdleAfter)
+	Interval     time.Duration
+	BatchSize    int
+	LinkTTL      time.Duration // how long restore links work
+}
+
+// AbandonedCartReminder finds carts left alone for longer than the configured idle
+// times and publishes a reminder for each to Kafka, where StartCartReminderConsumer
+// emails it. Reminders are counted per cart version, so a cart gets at most
+// MaxReminders until the user changes it.
+type AbandonedCartReminder struct {
+	producer *kafka.Producer
+	config   *CartReminderConfig
+}
+
+func NewAbandonedCartReminder(producer *kafka.Producer, config *CartReminderConfig) *AbandonedCartReminder {
+	return &AbandonedCartReminder{
+		producer: producer,
+		config:   config,
+	}
+}
+
+// Start looks for abandoned carts every interval. It never returns.
+func (j *AbandonedCartReminder) Start() {
+	log.Printf("Starting abandoned cart reminder, idle after: %v, interval: %s", j.config.IdleAfter, j.config.Interval)
+
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if sent := j.Remind(); sent > 0 {
+			log.Printf("abandoned cart reminder sent %d reminders", sent)
+		}
+	}
+}
+
+// Remind publishes reminders for one batch of abandoned carts and returns how many
+// were published.
+func (j *AbandonedCartReminder) Remind() int {
+	now := time.Now()
+	idleAfter := j.config.IdleAfter[:min(j.config.MaxReminders, len(j.config.IdleAfter))]
+	idleBefore := make([]time.Time, len(idleAfter))
+	for n, idle := range idleAfter {
+		idleBefore[n] = now.Add(-idle)
+	}
+
+	carts, err := dao.GetAbandonedCarts(idleBefore, j.config.BatchSize)
+	if err != nil {
+		log.Printf("unable to fetch abandoned carts, err : %s", err)
+		return 0
+	}
+
+	sent := 0
+	for _, cart := range carts {
+		event, err := j.recordReminder(cart, now)
+		if err != nil {
+			log.Printf("unable to record cart reminder for user %s, err : %s", cart.UserID, err)
+			continue
+		}
+		if event == nil {
+			continue
+		}
+
+		// The reminder counts as sent even if publishing fails, a cart never gets more
+		// than MaxReminders
+		if err := j.producer.PublishAbandonedCart(event); err != nil {
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// recordReminder stores the next reminder of a cart with the items it lists. A nil
+// event means the cart changed or emptied since it was found, or another instance
+// recorded the reminder first.
+func (j *AbandonedCartReminder) recordReminder(cart *models.AbandonedCart, now time.Time) (*kafka.AbandonedCartEvent, error) {
+	restoreToken, err := utils.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	unsubscribeToken, err := utils.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := dao.GetCartVersion(tx, models.CartOwner{UserID: cart.UserID})
+	if err != nil || version != cart.CartVersion {
+		tx.Rollback()
+		return nil, err
+	}
+
+	items, err := dao.GetCartItems(tx, cart.UserID)
+	if err != nil || len(items) == 0 {
+		tx.Rollback()
+		return nil, err
+	}
+
+	reminder := models.CartReminder{
+		ID:                   utils.NewID(),
+		UserID:               cart.UserID,
+		CartVersion:          cart.CartVersion,
+		ReminderNumber:       cart.RemindersSent + 1,
+		RestoreTokenHash:     utils.HashToken(restoreToken),
+		UnsubscribeTokenHash: utils.HashToken(unsubscribeToken),
+		ExpiresDate:          now.Add(j.config.LinkTTL),
+		CreatedDate:          now,
+	}
+	created, err := dao.CreateCartReminder(tx, &reminder)
+	if err != nil || !created {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := dao.CreateCartReminderItems(tx, reminder.ID, items); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	var subtotal float32
+	for _, item := range items {
+		subtotal += item.LineTotal()
+	}
+	return &kafka.AbandonedCartEvent{
+		Event:           kafka.EventAbandonedCart,
+		ReminderID:      reminder.ID,
+		UserID:          reminder.UserID,
+		ReminderNumber:  reminder.ReminderNumber,
+		Items:           items,
+		Subtotal:        subtotal,
+		RestoreLink:     j.config.AppBaseURL + "/restore-cart?token=" + url.QueryEscape(restoreToken),
+		UnsubscribeLink: j.config.AppBaseURL + "/unsubscribe/cart-reminders?token=" + url.QueryEscape(unsubscribeToken),
+		CreatedDate:     now,
+	}, nil
+}
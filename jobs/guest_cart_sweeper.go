@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"ecommerce/database/dao"
+	"log"
+	"time"
+)
+
+// GuestCartSweeper deletes guest carts that expired without being merged into an account
+type GuestCartSweeper struct {
+	interval time.Duration
+}
+
+func NewGuestCartSweeper(interval time.Duration) *GuestCartSweeper {
+	return &GuestCartSweeper{interval: interval}
+}
+
+// Start sweeps expired guest carts every interval. It never returns.
+func (s *GuestCartSweeper) Start() {
+	log.Printf("Starting guest cart sweeper, interval: %s", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := dao.DeleteExpiredGuestCarts(time.Now())
+		if err != nil {
+			log.Printf("unable to delete expired guest carts, err : %s", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("guest cart sweeper deleted %d carts", deleted)
+		}
+	}
+}
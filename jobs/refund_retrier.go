@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/gateway"
+	"log"
+	"time"
+)
+
+// RefundRetrier issues refunds that failed when an order was canceled or refunded, or
+// when a payment arrived for an order that no longer awaited it. Payments wait in
+// refund_pending for at least one interval, so a refund still in flight is not
+// retried, and the payment ID is sent as idempotency key.
+type RefundRetrier struct {
+	gateway   gateway.PaymentGateway
+	interval  time.Duration
+	batchSize int
+}
+
+func NewRefundRetrier(paymentGateway gateway.PaymentGateway, interval time.Duration, batchSize int) *RefundRetrier {
+	return &RefundRetrier{
+		gateway:   paymentGateway,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Start retries pending refunds every interval. It never returns.
+func (j *RefundRetrier) Start() {
+	log.Printf("Starting refund retrier, interval: %s", j.interval)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if refunded := j.Retry(); refunded > 0 {
+			log.Printf("refund retrier refunded %d payments", refunded)
+		}
+	}
+}
+
+// Retry refunds one batch of payments waiting for a refund and returns how many were
+// refunded.
+func (j *RefundRetrier) Retry() int {
+	before := time.Now().Add(-j.interval)
+
+	payments, err := dao.GetRefundPendingPayments(before, j.batchSize)
+	if err != nil {
+		log.Printf("unable to fetch payments waiting for a refund, err : %s", err)
+		return 0
+	}
+
+	refunded := 0
+	for _, payment := range payments {
+		claimed, err := dao.ClaimRefundPendingPayment(payment.ID, before)
+		if err != nil || !claimed {
+			continue
+		}
+
+		refund, err := j.gateway.Refund(&gateway.RefundRequest{
+			TransactionID:  payment.TransactionId,
+			Amount:         payment.Amount,
+			Reason:         "refund of order " + payment.OrderID,
+			IdempotencyKey: payment.ID,
+		})
+		if err != nil {
+			log.Printf("unable to refund payment %s of order %s, err : %s", payment.ID, payment.OrderID, err)
+			continue
+		}
+
+		if err := dao.MarkPaymentRefunded(database.DB, payment.ID, refund.ID); err != nil {
+			log.Printf("refund %s issued but payment %s not updated, err : %s", refund.ID, payment.ID, err)
+			continue
+		}
+		refunded++
+	}
+	return refunded
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/kafka"
+	"ecommerce/models"
+	"log"
+	"time"
+)
+
+// ReservationSweeper cancels orders that stayed unpaid longer than the reservation
+// TTL and releases the stock reserved for them by CreateOrder.
+type ReservationSweeper struct {
+	producer          *kafka.Producer
+	inventoryProducer *kafka.Producer
+	ttl               time.Duration
+	interval          time.Duration
+	batchSize         int
+}
+
+func NewReservationSweeper(producer, inventoryProducer *kafka.Producer, ttl, interval time.Duration, batchSize int) *ReservationSweeper {
+	return &ReservationSweeper{
+		producer:          producer,
+		inventoryProducer: inventoryProducer,
+		ttl:               ttl,
+		interval:          interval,
+		batchSize:         batchSize,
+	}
+}
+
+// Start sweeps expired orders every interval. It never returns.
+func (s *ReservationSweeper) Start() {
+	log.Printf("Starting reservation sweeper, ttl: %s, interval: %s", s.ttl, s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if expired := s.Sweep(); expired > 0 {
+			log.Printf("reservation sweeper expired %d orders", expired)
+		}
+	}
+}
+
+// Sweep expires one batch of unpaid orders and returns how many were expired.
+func (s *ReservationSweeper) Sweep() int {
+	before := time.Now().Add(-s.ttl)
+
+	orderIDs, err := dao.GetExpiredOrderIDs(before, s.batchSize)
+	if err != nil {
+		log.Printf("unable to fetch expired orders, err : %s", err)
+		return 0
+	}
+
+	expired := 0
+	for _, orderID := range orderIDs {
+		transition, released, err := expireOrder(orderID, before)
+		if err != nil {
+			log.Printf("unable to expire order %s, err : %s", orderID, err)
+			continue
+		}
+		if transition == nil {
+			continue
+		}
+
+		expired++
+		s.producer.PublishOrderExpired(transition)
+		s.inventoryProducer.PublishStockChanges(released)
+	}
+	return expired
+}
+
+// expireOrder cancels a single order in its own transaction and returns the products
+// whose reservation was released. A nil transition means the order was paid, canceled
+// or picked up by another instance in the meantime.
+func expireOrder(orderID string, before time.Time) (*models.OrderStatusTransition, []*models.StockChange, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	locked, err := dao.LockExpiredOrder(tx, orderID, before)
+	if err != nil || !locked {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	transition, err := dao.TransitionOrderStatus(tx, orderID, models.OrderStatusCanceled, models.ActorSystem, "order expired: payment not received in time")
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	released, err := dao.RestoreReservedStock(tx, orderID)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := dao.ExpirePendingPayments(tx, orderID); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return transition, released, nil
+}
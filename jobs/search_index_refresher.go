@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"ecommerce/database/dao"
+	"ecommerce/search"
+	"log"
+	"time"
+)
+
+// SearchIndexRefresher rebuilds the search index from the database. Product events
+// keep the index current between rebuilds, the rebuild picks up what they do not
+// carry, such as stock changes from orders, and repairs missed events.
+type SearchIndexRefresher struct {
+	index    *search.Index
+	interval time.Duration
+}
+
+func NewSearchIndexRefresher(index *search.Index, interval time.Duration) *SearchIndexRefresher {
+	return &SearchIndexRefresher{
+		index:    index,
+		interval: interval,
+	}
+}
+
+// Start rebuilds the index every interval. It never returns.
+func (s *SearchIndexRefresher) Start() {
+	log.Printf("Starting search index refresher, interval: %s", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.Refresh()
+	}
+}
+
+// Refresh rebuilds the index once
+func (s *SearchIndexRefresher) Refresh() error {
+	products, err := dao.GetAllProducts()
+	if err != nil {
+		log.Printf("unable to load products for the search index, err : %s", err)
+		return err
+	}
+
+	s.index.Rebuild(products)
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"ecommerce/database/dao"
 	"ecommerce/models"
 	"ecommerce/notifications"
+	"ecommerce/search"
 	"encoding/json"
 	"log"
 	"time"
@@ -12,9 +13,76 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
+// Events published on the order status topic
+const (
+	EventOrderStatusChanged = "order_status_changed"
+	EventOrderExpired       = "order_expired"
+)
+
 type OrderStatusEvent struct {
-	OrderID string `json:"order_id"`
-	Status  string `json:"status"`
+	Event          string    `json:"event"`
+	OrderID        string    `json:"order_id"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status"`
+	Actor          string    `json:"actor"`
+	Reason         string    `json:"reason"`
+	ChangedDate    time.Time `json:"changed_date"`
+}
+
+// Events published on the user notifications topic
+const (
+	EventAccountCreated    = "account_created"
+	EventEmailVerification = "email_verification"
+	EventPasswordReset     = "password_reset"
+	EventAccountLocked     = "account_locked"
+)
+
+// UserNotificationEvent asks for an email to be sent to a user. Link carries the
+// verification or reset link for the events that need one.
+type UserNotificationEvent struct {
+	Event     string `json:"event"`
+	UserID    string `json:"id"`
+	FirstName string `json:"first_name"`
+	Email     string `json:"email"`
+	Link      string `json:"link,omitempty"`
+}
+
+// Events published on the inventory updates topic
+const (
+	EventProductCreated = "product_created"
+	EventProductUpdated = "product_updated"
+	EventProductDeleted = "product_deleted"
+)
+
+// ProductEvent announces a change to the catalogue. Product is the product after the
+// change and is empty for deletions. Updates carry the previous price and stock.
+type ProductEvent struct {
+	Event                  string          `json:"event"`
+	ProductID              string          `json:"product_id"`
+	Product                *models.Product `json:"product,omitempty"`
+	PreviousPrice          *float64        `json:"previous_price,omitempty"`
+	PreviousStock          *int            `json:"previous_stock,omitempty"`
+	PreviousAvailableStock *int            `json:"previous_available_stock,omitempty"` // stock not reserved by pending orders
+	ChangedDate            time.Time       `json:"changed_date"`
+}
+
+// Events published on the cart reminders topic
+const (
+	EventAbandonedCart = "abandoned_cart"
+)
+
+// AbandonedCartEvent asks for a reminder email about a cart left alone. Items are the
+// cart's lines when the reminder was recorded.
+type AbandonedCartEvent struct {
+	Event           string                   `json:"event"`
+	ReminderID      string                   `json:"reminder_id"`
+	UserID          string                   `json:"user_id"`
+	ReminderNumber  int                      `json:"reminder_number"`
+	Items           []*models.ProductDetails `json:"items"`
+	Subtotal        float32                  `json:"subtotal"`
+	RestoreLink     string                   `json:"restore_link"`
+	UnsubscribeLink string                   `json:"unsubscribe_link"`
+	CreatedDate     time.Time                `json:"created_date"`
 }
 
 type UserInfo struct {
@@ -52,8 +120,13 @@ func StartOrderConsumer(emailConfig *notifications.EmailConfig, broker []string,
 			continue
 		}
 
-		if err := emailConfig.NotifyOrderStatus(orderDetails, event.Status); err != nil {
-			log.Printf("Failed to send order status email: %v", err)
+		if event.Event == EventOrderExpired {
+			err = emailConfig.NotifyOrderExpired(orderDetails)
+		} else {
+			err = emailConfig.NotifyOrderStatus(orderDetails, event.Status)
+		}
+		if err != nil {
+			log.Printf("Failed to send %s email: %v", event.Event, err)
 		}
 	}
 }
@@ -74,14 +147,168 @@ func StartUserConsumer(emailConfig *notifications.EmailConfig, broker []string,
 			continue
 		}
 
-		var user models.User
-		if err := json.Unmarshal(message.Value, &user); err != nil {
+		var event UserNotificationEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
+			log.Printf("Failed to parse message: %v", err)
+			continue
+		}
+
+		user := models.User{
+			ID:        event.UserID,
+			FirstName: event.FirstName,
+			Email:     event.Email,
+		}
+
+		switch event.Event {
+		case EventEmailVerification:
+			err = emailConfig.NotifyEmailVerification(&user, event.Link)
+		case EventPasswordReset:
+			err = emailConfig.NotifyPasswordReset(&user, event.Link)
+		case EventAccountLocked:
+			err = emailConfig.NotifyAccountLocked(&user, event.Link)
+		default:
+			// Messages published before events were typed are account creations
+			err = emailConfig.NotifyUserCreated(&user)
+		}
+		if err != nil {
+			log.Printf("Failed to send %s email: %v", event.Event, err)
+		}
+	}
+}
+
+// StartSearchIndexConsumer applies product events to the search index. Every instance
+// keeps its own index, so groupID must be unique per instance.
+func StartSearchIndexConsumer(index *search.Index, broker []string, topic, groupID string) error {
+	reader := newKafkaReader(broker, topic, groupID)
+	defer reader.Close()
+
+	log.Printf("Starting Kafka consumer for topic: %s, groupID: %s", topic, groupID)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		message, err := reader.ReadMessage(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to read message from topic %s: %v", topic, err)
+			continue
+		}
+
+		var event ProductEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
+			log.Printf("Failed to parse message: %v", err)
+			continue
+		}
+
+		switch {
+		case event.Event == EventProductDeleted:
+			index.Remove(event.ProductID)
+		case event.Product != nil:
+			index.Upsert(event.Product)
+		}
+	}
+}
+
+// StartWishlistConsumer emails the users who have a product on a wishlist when the
+// product comes back in stock or gets cheaper. A user is told a product is back in
+// stock at most once per alertCooldown.
+func StartWishlistConsumer(emailConfig *notifications.EmailConfig, alertCooldown time.Duration, broker []string, topic, groupID string) error {
+	reader := newKafkaReader(broker, topic, groupID)
+	defer reader.Close()
+
+	log.Printf("Starting Kafka consumer for topic: %s, groupID: %s", topic, groupID)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		message, err := reader.ReadMessage(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to read message from topic %s: %v", topic, err)
+			continue
+		}
+
+		var event ProductEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
 			log.Printf("Failed to parse message: %v", err)
 			continue
 		}
+		if event.Event != EventProductUpdated || event.Product == nil || event.PreviousPrice == nil || event.PreviousAvailableStock == nil {
+			continue
+		}
+
+		product := event.Product
+		// Stock held by pending orders cannot be bought, a product is back once some is free
+		backInStock := *event.PreviousAvailableStock <= 0 && product.AvailableStock() > 0
+		priceDrop := product.Price < *event.PreviousPrice
+		if !backInStock && !priceDrop {
+			continue
+		}
+
+		watchers, err := dao.GetWishlistWatchers(product.ID)
+		if err != nil {
+			log.Printf("Unable to fetch wishlist watchers of product %s: %v", product.ID, err)
+			continue
+		}
+
+		var previousPrice *float64
+		if priceDrop {
+			previousPrice = event.PreviousPrice
+		}
+		for _, user := range watchers {
+			userBackInStock := backInStock
+			if backInStock {
+				if userBackInStock, err = dao.ClaimBackInStockAlert(user.ID, product.ID, alertCooldown); err != nil {
+					log.Printf("Unable to record back in stock alert of product %s for user %s: %v", product.ID, user.ID, err)
+					continue
+				}
+			}
+			if !userBackInStock && !priceDrop {
+				continue
+			}
+
+			if err := emailConfig.NotifyWishlistProduct(user, product, previousPrice, userBackInStock); err != nil {
+				log.Printf("Failed to send wishlist email: %v", err)
+			}
+		}
+	}
+}
+
+// StartCartReminderConsumer emails abandoned cart reminders. Users who opted out or
+// closed their account after the reminder was recorded get nothing.
+func StartCartReminderConsumer(emailConfig *notifications.EmailConfig, broker []string, topic, groupID string) error {
+	reader := newKafkaReader(broker, topic, groupID)
+	defer reader.Close()
+
+	log.Printf("Starting Kafka consumer for topic: %s, groupID: %s", topic, groupID)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		message, err := reader.ReadMessage(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to read message from topic %s: %v", topic, err)
+			continue
+		}
+
+		var event AbandonedCartEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
+			log.Printf("Failed to parse message: %v", err)
+			continue
+		}
+		if event.Event != EventAbandonedCart || len(event.Items) == 0 {
+			continue
+		}
+
+		user, err := dao.GetUserByID(event.UserID)
+		if err != nil {
+			log.Printf("Unable to fetch user by ID %s: %v", event.UserID, err)
+			continue
+		}
+		if user.CartRemindersOptOut {
+			continue
+		}
 
-		if err := emailConfig.NotifyUserCreated(&user); err != nil {
-			log.Printf("Failed to send user created email: %v", err)
+		if err := emailConfig.NotifyAbandonedCart(user, event.Items, event.Subtotal, event.RestoreLink, event.UnsubscribeLink); err != nil {
+			log.Printf("Failed to send abandoned cart email: %v", err)
 		}
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"ecommerce/models"
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
@@ -27,11 +28,25 @@ func NewProducer(broker []string, topic string) *Producer {
 	}
 }
 
-// PublishOrderStatus sends an order status update to Kafka.
-func (p *Producer) PublishOrderStatus(orderID, status string) error {
-	value, err := json.Marshal(map[string]string{
-		"order_id": orderID,
-		"status":   status,
+// PublishOrderStatus sends an order status transition to Kafka.
+func (p *Producer) PublishOrderStatus(transition *models.OrderStatusTransition) error {
+	return p.publishOrderEvent(EventOrderStatusChanged, transition)
+}
+
+// PublishOrderExpired sends the cancellation of an unpaid order whose reservation ran out.
+func (p *Producer) PublishOrderExpired(transition *models.OrderStatusTransition) error {
+	return p.publishOrderEvent(EventOrderExpired, transition)
+}
+
+func (p *Producer) publishOrderEvent(event string, transition *models.OrderStatusTransition) error {
+	value, err := json.Marshal(OrderStatusEvent{
+		Event:          event,
+		OrderID:        transition.OrderID,
+		Status:         transition.ToStatus,
+		PreviousStatus: transition.FromStatus,
+		Actor:          transition.Actor,
+		Reason:         transition.Reason,
+		ChangedDate:    transition.CreatedDate,
 	})
 	if err != nil {
 		log.Printf("Failed to marshal order status: %v", err)
@@ -39,7 +54,7 @@ func (p *Producer) PublishOrderStatus(orderID, status string) error {
 	}
 
 	message := kafka.Message{
-		Key:   []byte(orderID),
+		Key:   []byte(transition.OrderID),
 		Value: value,
 	}
 
@@ -48,7 +63,7 @@ func (p *Producer) PublishOrderStatus(orderID, status string) error {
 		return err
 	}
 
-	log.Printf("Published order status: OrderID=%s, Status=%s", orderID, status)
+	log.Printf("Published %s: OrderID=%s, Status=%s -> %s", event, transition.OrderID, transition.FromStatus, transition.ToStatus)
 	return nil
 }
 
@@ -58,19 +73,25 @@ func (p *Producer) Close() {
 
 // Publish user created
 func (p *Producer) PublishUserAccountCreated(userInfo *models.User) error {
-	value, err := json.Marshal(map[string]string{
-		"id":         userInfo.ID,
-		"first_name": userInfo.FirstName,
-		"email":      userInfo.Email,
+	return p.PublishUserNotification(&UserNotificationEvent{
+		Event:     EventAccountCreated,
+		UserID:    userInfo.ID,
+		FirstName: userInfo.FirstName,
+		Email:     userInfo.Email,
 	})
+}
+
+// PublishUserNotification asks the user consumer to send an email to a user
+func (p *Producer) PublishUserNotification(event *UserNotificationEvent) error {
+	value, err := json.Marshal(event)
 	if err != nil {
-		log.Printf("Failed to marshal order status: %v", err)
+		log.Printf("Failed to marshal user notification: %v", err)
 		return err
 	}
 
 	message := kafka.Message{
-		Key:   []byte(userInfo.ID),
-		Value: []byte(value),
+		Key:   []byte(event.UserID),
+		Value: value,
 	}
 
 	err = p.writer.WriteMessages(context.Background(), message)
@@ -79,6 +100,84 @@ func (p *Producer) PublishUserAccountCreated(userInfo *models.User) error {
 		return err
 	}
 
-	log.Printf("Published user created: userID=%s, email=%s", userInfo.ID, userInfo.Email)
+	log.Printf("Published %s: userID=%s, email=%s", event.Event, event.UserID, event.Email)
+	return nil
+}
+
+// PublishProductEvent announces that a product was created or updated. previous is
+// the product before an update, it lets consumers see what changed.
+func (p *Producer) PublishProductEvent(event string, product, previous *models.Product) error {
+	productEvent := ProductEvent{
+		Event:       event,
+		ProductID:   product.ID,
+		Product:     product,
+		ChangedDate: time.Now(),
+	}
+	if previous != nil {
+		productEvent.PreviousPrice = &previous.Price
+		productEvent.PreviousStock = &previous.Stock
+		previousAvailable := previous.AvailableStock()
+		productEvent.PreviousAvailableStock = &previousAvailable
+	}
+	return p.publishProductEvent(&productEvent)
+}
+
+// PublishStockChanges announces products whose stock moved without a catalogue edit,
+// e.g. stock released by a canceled order, as product updates
+func (p *Producer) PublishStockChanges(changes []*models.StockChange) {
+	for _, change := range changes {
+		p.PublishProductEvent(EventProductUpdated, change.Product, change.Previous)
+	}
+}
+
+// PublishProductDeleted announces that a product was removed from the catalogue
+func (p *Producer) PublishProductDeleted(productID string) error {
+	return p.publishProductEvent(&ProductEvent{
+		Event:       EventProductDeleted,
+		ProductID:   productID,
+		ChangedDate: time.Now(),
+	})
+}
+
+func (p *Producer) publishProductEvent(event *ProductEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal product event: %v", err)
+		return err
+	}
+
+	message := kafka.Message{
+		Key:   []byte(event.ProductID),
+		Value: value,
+	}
+
+	if err := p.writer.WriteMessages(context.Background(), message); err != nil {
+		log.Printf("Failed to write message to Kafka: %v", err)
+		return err
+	}
+
+	log.Printf("Published %s: productID=%s", event.Event, event.ProductID)
+	return nil
+}
+
+// PublishAbandonedCart asks the cart reminder consumer to email a reminder
+func (p *Producer) PublishAbandonedCart(event *AbandonedCartEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal abandoned cart event: %v", err)
+		return err
+	}
+
+	message := kafka.Message{
+		Key:   []byte(event.UserID),
+		Value: value,
+	}
+
+	if err := p.writer.WriteMessages(context.Background(), message); err != nil {
+		log.Printf("Failed to write message to Kafka: %v", err)
+		return err
+	}
+
+	log.Printf("Published %s: userID=%s, reminder=%d", event.Event, event.UserID, event.ReminderNumber)
 	return nil
 }
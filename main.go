@@ -2,13 +2,22 @@ package main
 
 import (
 	"ecommerce/database"
+	"ecommerce/database/dao"
+	"ecommerce/gateway"
 	"ecommerce/handlers"
+	"ecommerce/jobs"
 	"ecommerce/kafka"
+	"ecommerce/middleware"
+	"ecommerce/models"
 	"ecommerce/notifications"
 	"ecommerce/routes"
+	"ecommerce/search"
+	"ecommerce/utils"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
@@ -21,6 +30,21 @@ func main() {
 	db := database.ConnectDB(config.Database.DataSourceName, config.Database.DriverName)
 	defer db.Close()
 
+	utils.JWTKeys, err = utils.LoadJWTKeys(config.JWT.SigningKeyID, config.JWT.Keys)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// Token lifetimes and the revocation store checked on every request
+	utils.AccessTokenTTL = time.Duration(config.Auth.AccessTokenTTLMinutes) * time.Minute
+	utils.RefreshTokenTTL = time.Duration(config.Auth.RefreshTokenTTLHours) * time.Hour
+	utils.IsTokenRevoked = dao.IsTokenRevoked
+	middleware.AdminRequiresTwoFactor = config.Auth.TwoFactor.RequiredForAdmins
+
+	if n := len(config.Auth.TwoFactor.EncryptionKey); n != 16 && n != 24 && n != 32 {
+		log.Fatalf("auth.two_factor.encryption_key must be 16, 24 or 32 bytes long, got %d", n)
+	}
+
 	emailConfig := notifications.NewEmailConfig(config.Email.SMTPPort, config.Email.SMTPHost, config.Email.Username, config.Email.Password, config.Email.FromAddress)
 
 	// Initialize Kafka producer
@@ -30,6 +54,22 @@ func main() {
 	userProducer := kafka.NewProducer(config.Kafka.BrokerList, config.Kafka.Topics["user_notifications"])
 	defer userProducer.Close()
 
+	inventoryProducer := kafka.NewProducer(config.Kafka.BrokerList, config.Kafka.Topics["inventory_updates"])
+	defer inventoryProducer.Close()
+
+	cartReminderProducer := kafka.NewProducer(config.Kafka.BrokerList, config.Kafka.Topics["cart_reminders"])
+	defer cartReminderProducer.Close()
+
+	// Load the product search index before serving, then keep it fresh
+	requirePositive("search.refresh_interval_minutes", config.Search.RefreshIntervalMinutes)
+	searchIndex := search.NewIndex(config.Search.PriceBuckets)
+	refresher := jobs.NewSearchIndexRefresher(searchIndex, time.Duration(config.Search.RefreshIntervalMinutes)*time.Minute)
+	if err := refresher.Refresh(); err != nil {
+		log.Fatalf("Failed to build search index: %v", err)
+	}
+	log.Printf("Search index loaded with %d products", searchIndex.Len())
+	go refresher.Start()
+
 	// Start Kafka consumers in a separate Goroutine
 	go func() {
 		err := kafka.StartOrderConsumer(emailConfig, config.Kafka.BrokerList, config.Kafka.Topics["order_status"], config.Kafka.ConsumerGroups["order_status_group"])
@@ -45,12 +85,122 @@ func main() {
 		}
 	}()
 
-	payment := handlers.NewPayment(orderProducer)
+	hostname, _ := os.Hostname()
+	go func() {
+		err := kafka.StartSearchIndexConsumer(searchIndex, config.Kafka.BrokerList, config.Kafka.Topics["inventory_updates"], config.Kafka.ConsumerGroups["search_index_group"]+"-"+hostname)
+		if err != nil {
+			log.Printf("Consumer error for topic 'inventory_updates': %v", err)
+		}
+	}()
+
+	requirePositive("wishlist.alert_cooldown_hours", config.Wishlist.AlertCooldownHours)
+	alertCooldown := time.Duration(config.Wishlist.AlertCooldownHours) * time.Hour
+	go func() {
+		err := kafka.StartWishlistConsumer(emailConfig, alertCooldown, config.Kafka.BrokerList, config.Kafka.Topics["inventory_updates"], config.Kafka.ConsumerGroups["wishlist_notifications_group"])
+		if err != nil {
+			log.Printf("Consumer error for topic 'inventory_updates': %v", err)
+		}
+	}()
+
+	go func() {
+		err := kafka.StartCartReminderConsumer(emailConfig, config.Kafka.BrokerList, config.Kafka.Topics["cart_reminders"], config.Kafka.ConsumerGroups["cart_reminders_group"])
+		if err != nil {
+			log.Printf("Consumer error for topic 'cart_reminders': %v", err)
+		}
+	}()
+
+	// Start the sweeper releasing stock held by unpaid orders
+	requirePositive("orders.reservation_ttl_minutes", config.Orders.ReservationTTLMinutes)
+	requirePositive("orders.sweep_interval_seconds", config.Orders.SweepIntervalSeconds)
+	requirePositive("orders.sweep_batch_size", config.Orders.SweepBatchSize)
+	sweeper := jobs.NewReservationSweeper(orderProducer, inventoryProducer,
+		time.Duration(config.Orders.ReservationTTLMinutes)*time.Minute,
+		time.Duration(config.Orders.SweepIntervalSeconds)*time.Second,
+		config.Orders.SweepBatchSize)
+	go sweeper.Start()
+
+	requirePositive("cart.guest_cart_ttl_hours", config.Cart.GuestCartTTLHours)
+	requirePositive("cart.guest_cart_sweep_interval_minutes", config.Cart.GuestCartSweepIntervalMinutes)
+	guestCartSweeper := jobs.NewGuestCartSweeper(time.Duration(config.Cart.GuestCartSweepIntervalMinutes) * time.Minute)
+	go guestCartSweeper.Start()
+
+	switch config.Cart.MergeStrategy {
+	case models.CartMergeSum, models.CartMergeLatest:
+	default:
+		log.Fatalf("cart.merge_strategy must be %q or %q, got %q", models.CartMergeSum, models.CartMergeLatest, config.Cart.MergeStrategy)
+	}
+	reminders := config.Cart.Reminders
+	if len(reminders.IdleHours) == 0 {
+		log.Fatalf("cart.reminders.idle_hours must list at least one idle time")
+	}
+	idleAfter := make([]time.Duration, len(reminders.IdleHours))
+	for n, hours := range reminders.IdleHours {
+		requirePositive(fmt.Sprintf("cart.reminders.idle_hours[%d]", n), hours)
+		idleAfter[n] = time.Duration(hours) * time.Hour
+	}
+	requirePositive("cart.reminders.check_interval_minutes", reminders.CheckIntervalMinutes)
+	requirePositive("cart.reminders.max_reminders", reminders.MaxReminders)
+	requirePositive("cart.reminders.batch_size", reminders.BatchSize)
+	requirePositive("cart.reminders.link_ttl_hours", reminders.LinkTTLHours)
+	cartReminder := jobs.NewAbandonedCartReminder(cartReminderProducer, &jobs.CartReminderConfig{
+		AppBaseURL:   config.Auth.AppBaseURL,
+		IdleAfter:    idleAfter,
+		MaxReminders: reminders.MaxReminders,
+		Interval:     time.Duration(reminders.CheckIntervalMinutes) * time.Minute,
+		BatchSize:    reminders.BatchSize,
+		LinkTTL:      time.Duration(reminders.LinkTTLHours) * time.Hour,
+	})
+	go cartReminder.Start()
+
+	cartConfig := handlers.CartConfig{
+		GuestCartTTL:  time.Duration(config.Cart.GuestCartTTLHours) * time.Hour,
+		MergeStrategy: config.Cart.MergeStrategy,
+	}
+
+	paymentGateway, err := gateway.New(config.Payment.Gateway, config.Payment.BaseURL)
+	if err != nil {
+		log.Fatalf("Failed to set up payment gateway: %v", err)
+	}
+
+	// Retry refunds the gateway failed to issue when orders were canceled or refunded
+	requirePositive("payment.refund_retry_minutes", config.Payment.RefundRetryMinutes)
+	requirePositive("payment.refund_retry_batch_size", config.Payment.RefundRetryBatchSize)
+	refundRetrier := jobs.NewRefundRetrier(paymentGateway, time.Duration(config.Payment.RefundRetryMinutes)*time.Minute, config.Payment.RefundRetryBatchSize)
+	go refundRetrier.Start()
+
+	requirePositive("payment.webhook_tolerance_seconds", config.Payment.WebhookToleranceSeconds)
+	webhookVerifier := utils.NewWebhookVerifier(config.Payment.WebhookSecrets, time.Duration(config.Payment.WebhookToleranceSeconds)*time.Second)
+
+	payment := handlers.NewPayment(orderProducer, inventoryProducer, paymentGateway, webhookVerifier)
 	// handler := handlers.NewHandle(payment)
-	user := handlers.NewUser(userProducer)
+	requirePositive("auth.login.failure_window_minutes", config.Auth.Login.FailureWindowMinutes)
+	user := handlers.NewUser(userProducer, &handlers.UserConfig{
+		AppBaseURL:           config.Auth.AppBaseURL,
+		PasswordResetTTL:     time.Duration(config.Auth.PasswordResetTTLMinutes) * time.Minute,
+		EmailVerificationTTL: time.Duration(config.Auth.EmailVerificationTTLHours) * time.Hour,
+		Login: handlers.LoginConfig{
+			MaxAccountFailures:   config.Auth.Login.MaxAccountFailures,
+			MaxIPFailures:        config.Auth.Login.MaxIPFailures,
+			LockoutDuration:      time.Duration(config.Auth.Login.LockoutMinutes) * time.Minute,
+			BackoffAfterFailures: config.Auth.Login.BackoffAfterFailures,
+			BackoffBase:          time.Duration(config.Auth.Login.BackoffBaseSeconds) * time.Second,
+			FailureWindow:        time.Duration(config.Auth.Login.FailureWindowMinutes) * time.Minute,
+			TrustForwardedFor:    config.Auth.Login.TrustForwardedFor,
+		},
+		TwoFactor: handlers.TwoFactorConfig{
+			Issuer:        config.Auth.TwoFactor.Issuer,
+			EncryptionKey: config.Auth.TwoFactor.EncryptionKey,
+			LoginTokenTTL: time.Duration(config.Auth.TwoFactor.LoginTokenTTLMinutes) * time.Minute,
+		},
+		Cart: cartConfig,
+	})
+	order := handlers.NewOrder(orderProducer, inventoryProducer, paymentGateway, config.Auth.RequireVerifiedEmailForCheckout)
+	product := handlers.NewProduct(inventoryProducer, searchIndex)
+	cart := handlers.NewCart(&cartConfig)
+	wishlist := handlers.NewWishlist(config.Auth.AppBaseURL)
 
 	// Set up Routes
-	router := routes.SetupRoutes(payment, user)
+	router := routes.SetupRoutes(payment, user, order, product, cart, wishlist)
 
 	port := config.Server.Port
 	// Start Server
@@ -68,3 +218,11 @@ func main() {
 	// }
 
 }
+
+// requirePositive stops the application when a setting that must be above zero, like
+// a job interval or batch size, is missing or not positive
+func requirePositive(setting string, value int) {
+	if value <= 0 {
+		log.Fatalf("%s must be greater than zero, got %d", setting, value)
+	}
+}
@@ -1,11 +1,37 @@
 package middleware
 
 import (
+	"context"
+	"ecommerce/models"
 	"ecommerce/utils"
 	"net/http"
 	"strings"
+	"time"
 )
 
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// AdminRequiresTwoFactor is set at start up. When true, admins only get admin
+// privileges with a token from a login that completed two-factor authentication.
+var AdminRequiresTwoFactor bool
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID    string
+	Role      string
+	TokenID   string
+	SessionID string
+	TwoFactor bool // the login completed a second factor
+	ExpiresAt time.Time
+}
+
+// IsAdmin reports whether the caller may use admin privileges
+func (p *Principal) IsAdmin() bool {
+	return p.Role == models.RoleAdmin && (p.TwoFactor || !AdminRequiresTwoFactor)
+}
+
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("Authorization")
@@ -14,12 +40,76 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		token = strings.TrimPrefix(token, "Bearer ")
-		if !utils.ValidateJWT(token) {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		authenticate(next, w, r, strings.TrimPrefix(token, "Bearer "))
+	}
+}
+
+// OptionalAuthMiddleware authenticates the caller when the request carries a token
+// and lets anonymous requests through without a principal. Invalid tokens are still
+// rejected.
+func OptionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
+		if !strings.HasPrefix(token, "Bearer ") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		authenticate(next, w, r, strings.TrimPrefix(token, "Bearer "))
+	}
+}
+
+func authenticate(next http.HandlerFunc, w http.ResponseWriter, r *http.Request, token string) {
+	claims, ok := utils.ValidateJWT(token)
+	if !ok {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+
+	principal := &Principal{
+		UserID:    claims.UserID,
+		Role:      claims.Role,
+		TokenID:   claims.TokenID,
+		SessionID: claims.SessionID,
+		TwoFactor: claims.TwoFactor,
+		ExpiresAt: claims.ExpiresAt,
+	}
+	ctx := context.WithValue(r.Context(), principalKey, principal)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// CurrentPrincipal returns the caller authenticated by AuthMiddleware, or nil on
+// routes that are not behind it
+func CurrentPrincipal(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey).(*Principal)
+	return principal
+}
+
+// UserID returns the ID of the user authenticated by AuthMiddleware
+func UserID(r *http.Request) string {
+	if principal := CurrentPrincipal(r); principal != nil {
+		return principal.UserID
+	}
+	return ""
+}
+
+// Role returns the role of the user authenticated by AuthMiddleware
+func Role(r *http.Request) string {
+	if principal := CurrentPrincipal(r); principal != nil {
+		return principal.Role
+	}
+	return ""
+}
 
-		next.ServeHTTP(w, r)
+// CanAccess reports whether the caller owns a resource of the given user or is an admin
+func CanAccess(r *http.Request, ownerID string) bool {
+	principal := CurrentPrincipal(r)
+	if principal == nil {
+		return false
 	}
+	return principal.UserID == ownerID || principal.IsAdmin()
 }
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"ecommerce/models"
+	"net/http"
+)
+
+// RequireRole only lets users with one of the given roles through. It must be
+// wrapped by AuthMiddleware, which puts the caller's role in the request context.
+func RequireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := CurrentPrincipal(r)
+		for _, allowed := range roles {
+			if principal == nil || principal.Role != allowed {
+				continue
+			}
+
+			if allowed == models.RoleAdmin && !principal.IsAdmin() {
+				http.Error(w, "Two-factor authentication required", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// PostalAddress is where a parcel goes. Orders keep a copy of it so that editing or
+// deleting an address book entry does not change orders already placed.
+type PostalAddress struct {
+	FullName   string `json:"full_name" db:"full_name"`
+	Phone      string `json:"phone" db:"phone"`
+	Line1      string `json:"line1" db:"line1"`
+	Line2      string `json:"line2,omitempty" db:"line2"`
+	City       string `json:"city" db:"city"`
+	State      string `json:"state" db:"state"`
+	PostalCode string `json:"postal_code" db:"postal_code"`
+	Country    string `json:"country" db:"country"` // ISO 3166-1 alpha-2 code
+}
+
+// Address is an entry of a user's address book
+type Address struct {
+	ID     string `json:"id" db:"id"`
+	UserID string `json:"user_id" db:"user_id"`
+	PostalAddress
+	DefaultShipping bool      `json:"default_shipping" db:"default_shipping"`
+	DefaultBilling  bool      `json:"default_billing" db:"default_billing"`
+	CreatedDate     time.Time `json:"created_date" db:"created_date"`
+	UpdatedDate     time.Time `json:"updated_date" db:"updated_date"`
+}
+
+// AddressRequest is the body of POST and PUT /users/me/addresses
+type AddressRequest struct {
+	PostalAddress
+	DefaultShipping bool `json:"default_shipping"`
+	DefaultBilling  bool `json:"default_billing"`
+}
+
+// AddressResponse is an address book entry as returned by the API
+type AddressResponse struct {
+	ID string `json:"id"`
+	PostalAddress
+	DefaultShipping bool      `json:"default_shipping"`
+	DefaultBilling  bool      `json:"default_billing"`
+	CreatedDate     time.Time `json:"created_date"`
+	UpdatedDate     time.Time `json:"updated_date"`
+}
+
+func NewAddressResponse(address *Address) *AddressResponse {
+	return &AddressResponse{
+		ID:              address.ID,
+		PostalAddress:   address.PostalAddress,
+		DefaultShipping: address.DefaultShipping,
+		DefaultBilling:  address.DefaultBilling,
+		CreatedDate:     address.CreatedDate,
+		UpdatedDate:     address.UpdatedDate,
+	}
+}
@@ -1,16 +1,55 @@
 package models
 
 import (
+	"errors"
 	"time"
 )
 
-// Cart structure
+var (
+	ErrInsufficientStock = errors.New("not enough stock available")
+	ErrCartItemNotFound  = errors.New("product is not in the cart")
+	ErrGuestCartNotFound = errors.New("guest cart not found or expired")
+)
+
+// How the lines of a guest cart are combined with the user's cart on login
+const (
+	CartMergeSum    = "sum"    // add the quantities of both carts
+	CartMergeLatest = "latest" // keep the quantity of the line changed last
+)
+
+// CartOwner identifies a cart, either the cart of a signed in user or a guest cart
+type CartOwner struct {
+	UserID      string
+	GuestCartID string
+}
+
+func (o CartOwner) IsGuest() bool {
+	return o.GuestCartID != ""
+}
+
+// GuestCart is the cart of a shopper who is not signed in. It is found by the hash of
+// the opaque token handed to the client and expires when left untouched.
+type GuestCart struct {
+	ID          string    `json:"id"`
+	TokenHash   string    `json:"-"`
+	ExpiresDate time.Time `json:"expires_date"`
+	CreatedDate time.Time `json:"created_date"`
+}
+
+// Cart is one line of a user's cart, there is at most one line per product. Lines of
+// guest carts carry GuestCartID instead of UserID.
 type Cart struct {
 	ID          string    `json:"id" db:"id"`
 	UserID      string    `json:"user_id" db:"user_id"`
+	GuestCartID string    `json:"-" db:"guest_cart_id"`
 	ProductID   string    `json:"product_id" db:"product_id"` // store list of product IDs in cart
 	Quantity    int       `json:"quantity" db:"quantity"`
 	CreatedDate time.Time `json:"created_date" db:"created_at"`
+	UpdatedDate time.Time `json:"updated_date" db:"updated_date"`
+}
+
+func (c *Cart) Owner() CartOwner {
+	return CartOwner{UserID: c.UserID, GuestCartID: c.GuestCartID}
 }
 
 type CartItems struct {
@@ -19,8 +58,109 @@ type CartItems struct {
 }
 
 type ProductDetails struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Price    float32 `json:"price"`
-	Quantity int     `json:"quantity"`
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Price      float32 `json:"price"`
+	Quantity   int     `json:"quantity"`
+	PriceAtAdd float32 `json:"-"` // price of a cart line when the customer last set it
+}
+
+// LineTotal is the price of all units of a cart line
+func (p *ProductDetails) LineTotal() float32 {
+	return p.Price * float32(p.Quantity)
+}
+
+// AddToCartRequest is the body of POST /cart, the quantity is added to any already in the cart
+type AddToCartRequest struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// CartItemRequest is the body of PUT /cart/items/{productID}, zero removes the item
+type CartItemRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+type CartItemResponse struct {
+	ProductID    string  `json:"product_id"`
+	Name         string  `json:"name"`
+	Price        float32 `json:"price"`
+	PriceAtAdd   float32 `json:"price_at_add"`
+	PriceChanged bool    `json:"price_changed"` // checkout will ask the customer to confirm the new price
+	Quantity     int     `json:"quantity"`
+	LineTotal    float32 `json:"line_total"`
+}
+
+type CartResponse struct {
+	UserID    string              `json:"user_id,omitempty"`
+	CartToken string              `json:"cart_token,omitempty"` // only when a guest cart was just created
+	Version   int                 `json:"version"`              // send as cart_version when checking out
+	Items     []*CartItemResponse `json:"items"`
+	ItemCount int                 `json:"item_count"` // units over all lines
+	Subtotal  float32             `json:"subtotal"`
+}
+
+func NewCartResponse(userID string, version int, items []*ProductDetails) *CartResponse {
+	response := &CartResponse{
+		UserID:  userID,
+		Version: version,
+		Items:   make([]*CartItemResponse, 0, len(items)),
+	}
+	for _, item := range items {
+		response.Items = append(response.Items, &CartItemResponse{
+			ProductID:    item.ID,
+			Name:         item.Name,
+			Price:        item.Price,
+			PriceAtAdd:   item.PriceAtAdd,
+			PriceChanged: item.PriceAtAdd != item.Price,
+			Quantity:     item.Quantity,
+			LineTotal:    item.LineTotal(),
+		})
+		response.ItemCount += item.Quantity
+		response.Subtotal += item.LineTotal()
+	}
+	return response
+}
+
+// CheckoutLine is a line of the cart being checked out with the current state of its
+// product, deleted products included
+type CheckoutLine struct {
+	ProductID    string
+	Name         string
+	Quantity     int
+	PriceAtAdd   float32
+	Price        float32
+	Available    int // stock not reserved by unpaid orders
+	Discontinued bool
+}
+
+// How a cart line changed since the customer last saw it
+const (
+	CartChangePriceChanged      = "price_changed"
+	CartChangeDiscontinued      = "discontinued"
+	CartChangeInsufficientStock = "insufficient_stock"
+)
+
+// CartChange is one difference found when revalidating a cart at checkout. A line can
+// have several, e.g. a new price and less stock.
+type CartChange struct {
+	ProductID         string  `json:"product_id"`
+	Name              string  `json:"name"`
+	Change            string  `json:"change"`
+	PreviousPrice     float32 `json:"previous_price,omitempty"`
+	Price             float32 `json:"price,omitempty"`
+	RequestedQuantity int     `json:"requested_quantity,omitempty"`
+	AvailableQuantity int     `json:"available_quantity"`
+}
+
+// CheckoutConflictResponse is returned by POST /orders with 409 when the cart is not
+// what the customer confirmed. The cart has been updated to match Changes and can be
+// checked out again with the new CartVersion and Total.
+type CheckoutConflictResponse struct {
+	Error         string        `json:"error"`
+	CartChanged   bool          `json:"cart_changed"` // the cart was edited after the given cart_version
+	CartVersion   int           `json:"cart_version"`
+	ExpectedTotal *float32      `json:"expected_total,omitempty"`
+	Total         float32       `json:"total"`
+	Changes       []*CartChange `json:"changes"`
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrCartReminderNotFound = errors.New("cart reminder not found or expired")
+
+// AbandonedCart is a user's cart that has not been touched for a while. RemindersSent
+// counts the reminders sent for the cart's current version, editing the cart starts
+// the count again.
+type AbandonedCart struct {
+	UserID        string
+	CartVersion   int
+	LastActivity  time.Time
+	RemindersSent int
+}
+
+// CartReminder is one abandoned cart email. The items of the cart are kept with it so
+// that its restore link brings back what the email listed. The restore and unsubscribe
+// links carry tokens of which only the SHA-256 hashes are stored.
+type CartReminder struct {
+	ID                   string
+	UserID               string
+	CartVersion          int
+	ReminderNumber       int // 1 for the first reminder of a cart version
+	RestoreTokenHash     string
+	UnsubscribeTokenHash string
+	ExpiresDate          time.Time // the restore link stops working after this, unsubscribing never expires
+	CreatedDate          time.Time
+}
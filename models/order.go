@@ -1,23 +1,165 @@
 package models
 
 import (
+	"errors"
 	"time"
 )
 
+// Order statuses
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusPacked    = "packed"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCanceled  = "canceled"
+	OrderStatusRefunded  = "refunded"
+	OrderStatusReturned  = "returned"
+)
+
+// Actors recorded in order_status_history besides user IDs
+const (
+	ActorSystem         = "system"
+	ActorPaymentGateway = "payment_gateway"
+)
+
+// ErrInvalidOrderTransition is returned when an order cannot move to the requested status
+var ErrInvalidOrderTransition = errors.New("invalid order status transition")
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Statuses missing from the map (canceled, refunded) are final. Only payment webhooks
+// move orders to paid, and moving to canceled or refunded also has to release stock
+// and refund the payment, see handlers.closeOrder.
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCanceled},
+	OrderStatusPaid:      {OrderStatusPacked, OrderStatusCanceled, OrderStatusRefunded},
+	OrderStatusPacked:    {OrderStatusShipped, OrderStatusCanceled},
+	OrderStatusShipped:   {OrderStatusDelivered, OrderStatusReturned},
+	OrderStatusDelivered: {OrderStatusReturned},
+	OrderStatusReturned:  {OrderStatusRefunded},
+}
+
+// CanTransitionOrder reports whether an order in status from may move to status to
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 // Order structure
 type Order struct {
-	ID          string    `json:"id" db:"id"`
-	UserID      string    `json:"user_id" db:"user_id"`
-	TotalPrice  float32   `json:"total_price" db:"total_price"`
-	Status      string    `json:"status" db:"status"` // Example: "pending", "shipped", "delivered"
-	CreatedDate time.Time `json:"created_date" db:"created_at"`
+	ID          string       `json:"id" db:"id"`
+	UserID      string       `json:"user_id" db:"user_id"`
+	TotalPrice  float32      `json:"total_price" db:"total_price"`
+	Status      string       `json:"status" db:"status"` // One of the OrderStatus constants
+	CreatedDate time.Time    `json:"created_date" db:"created_at"`
+	Items       []*OrderItem `json:"items,omitempty"`
+	// Copy of the address book entry the order ships to, nil for orders placed
+	// before addresses were introduced
+	ShippingAddress *PostalAddress `json:"shipping_address,omitempty"`
+}
+
+// CreateOrderRequest is the body of POST /orders. Without a shipping address ID the
+// default shipping address is used. CartVersion and ExpectedTotal are what the
+// customer confirmed, checkout is rejected when the cart no longer matches them.
+type CreateOrderRequest struct {
+	ShippingAddressID string   `json:"shipping_address_id"`
+	CartVersion       *int     `json:"cart_version"`
+	ExpectedTotal     *float32 `json:"expected_total"`
+}
+
+// OrderItem is the snapshot of a product taken when the order was placed
+type OrderItem struct {
+	ID          string  `json:"id" db:"id"`
+	ProductID   string  `json:"product_id" db:"product_id"`
+	ProductName string  `json:"product_name" db:"product_name"`
+	UnitPrice   float32 `json:"unit_price" db:"product_price"`
+	Quantity    int     `json:"quantity" db:"quantity"`
+}
+
+// OrderFilter narrows down the orders returned by GET /orders
+type OrderFilter struct {
+	Status string
+	From   time.Time // inclusive, zero means no lower bound
+	To     time.Time // exclusive, zero means no upper bound
+	Cursor string
+	Limit  int
 }
 
+// OrderPage is a page of orders with the cursor of the next page
+type OrderPage struct {
+	Orders     []*Order `json:"orders"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// OrderResponse is an order as returned by the API
+type OrderResponse struct {
+	ID              string         `json:"id"`
+	UserID          string         `json:"user_id"`
+	TotalPrice      float32        `json:"total_price"`
+	Status          string         `json:"status"`
+	CreatedDate     time.Time      `json:"created_date"`
+	Items           []*OrderItem   `json:"items,omitempty"`
+	ShippingAddress *PostalAddress `json:"shipping_address,omitempty"`
+}
+
+func NewOrderResponse(order *Order) *OrderResponse {
+	return &OrderResponse{
+		ID:              order.ID,
+		UserID:          order.UserID,
+		TotalPrice:      order.TotalPrice,
+		Status:          order.Status,
+		CreatedDate:     order.CreatedDate,
+		Items:           order.Items,
+		ShippingAddress: order.ShippingAddress,
+	}
+}
+
+// OrderPageResponse is a page of orders as returned by GET /orders
+type OrderPageResponse struct {
+	Orders     []*OrderResponse `json:"orders"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+func NewOrderPageResponse(page *OrderPage) *OrderPageResponse {
+	response := &OrderPageResponse{
+		Orders:     make([]*OrderResponse, 0, len(page.Orders)),
+		NextCursor: page.NextCursor,
+	}
+	for _, order := range page.Orders {
+		response.Orders = append(response.Orders, NewOrderResponse(order))
+	}
+	return response
+}
+
+// OrderDetails is an order with the contact details used for notifications, it is
+// never returned by the API
 type OrderDetails struct {
 	ID         string  `json:"id" db:"id"`
 	UserID     string  `json:"user_id" db:"user_id"`
 	TotalPrice float32 `json:"total_price" db:"total_price"`
-	Status     string  `json:"status" db:"status"` // Example: "pending", "shipped", "delivered"
-	Username   string
-	Email      string
+	Status     string  `json:"status" db:"status"` // One of the OrderStatus constants
+	Username   string  `json:"-"`
+	Email      string  `json:"-"`
+
+	ShippingAddress *PostalAddress `json:"-"`
+}
+
+// OrderStatusTransition is a row of order_status_history
+type OrderStatusTransition struct {
+	ID          string    `json:"id" db:"id"`
+	OrderID     string    `json:"order_id" db:"order_id"`
+	FromStatus  string    `json:"from_status" db:"from_status"`
+	ToStatus    string    `json:"to_status" db:"to_status"`
+	Actor       string    `json:"actor" db:"actor"` // user ID or one of the Actor constants
+	Reason      string    `json:"reason" db:"reason"`
+	CreatedDate time.Time `json:"created_date" db:"created_date"`
+}
+
+type OrderStatusUpdate struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
 }
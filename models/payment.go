@@ -2,24 +2,67 @@ package models
 
 import "time"
 
+// Payment statuses stored in payments.payment_status
+const (
+	PaymentStatusPending  = "pending"
+	PaymentStatusSuccess  = "success"
+	PaymentStatusFailed   = "failed"
+	PaymentStatusExpired  = "expired"
+	PaymentStatusRefunded = "refunded"
+	// PaymentStatusRefundPending is a captured payment that must be returned but whose
+	// refund the gateway has not confirmed yet
+	PaymentStatusRefundPending = "refund_pending"
+)
+
 type PaymentRequest struct {
 	OrderID string `json:"order_id"`
 	Method  string `json:"method"` // e.g., "card", "wallet"
 }
 
-type paymentDetails struct {
+type PaymentDetails struct {
+	ID            string    `json:"id" db:"id"`
+	OrderID       string    `json:"order_id" db:"order_id"`
+	TransactionId string    `json:"transaction_id" db:"transaction_id"`
+	SessionID     string    `json:"session_id" db:"session_id"` // checkout session at the payment gateway
+	RefundID      string    `json:"refund_id,omitempty" db:"refund_id"`
+	Method        string    `json:"method" db:"method"`
+	PaymentStatus string    `json:"payment_status" db:"payment_status"`
+	Amount        float32   `json:"amount" db:"amount"`
+	CreatedDate   time.Time `json:"created_date" db:"created_date"`
+	UpdatedDate   time.Time `json:"updated_date" db:"updated_date"`
+}
+
+// PaymentResponse is a payment as returned by the API. The gateway checkout session
+// stays internal.
+type PaymentResponse struct {
 	ID            string    `json:"id"`
 	OrderID       string    `json:"order_id"`
-	TransactionId string    `json:"transaction_id"`
-	PaymentStatus string    `json:"payment_status"`
-	Amount        int       `json:"amount"`
+	TransactionID string    `json:"transaction_id,omitempty"`
+	RefundID      string    `json:"refund_id,omitempty"`
+	Method        string    `json:"method"`
+	Status        string    `json:"status"`
+	Amount        float32   `json:"amount"`
 	CreatedDate   time.Time `json:"created_date"`
 	UpdatedDate   time.Time `json:"updated_date"`
 }
 
+func NewPaymentResponse(payment *PaymentDetails) *PaymentResponse {
+	return &PaymentResponse{
+		ID:            payment.ID,
+		OrderID:       payment.OrderID,
+		TransactionID: payment.TransactionId,
+		RefundID:      payment.RefundID,
+		Method:        payment.Method,
+		Status:        payment.PaymentStatus,
+		Amount:        payment.Amount,
+		CreatedDate:   payment.CreatedDate,
+		UpdatedDate:   payment.UpdatedDate,
+	}
+}
+
 type WebhookData struct {
-	TransactionID string `json:"transaction_id"`
-	OrderID       string `json:"order_id"`
-	Amount        int    `json:"amount"`
-	Status        string `json:"status"` // "success", "failed", etc.
+	TransactionID string  `json:"transaction_id"`
+	OrderID       string  `json:"order_id"`
+	Amount        float32 `json:"amount"`
+	Status        string  `json:"status"` // "success", "failed", etc.
 }
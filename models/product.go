@@ -1,17 +1,134 @@
 package models
 
 import (
+	"errors"
 	"time"
 )
 
+var (
+	ErrProductNotFound = errors.New("product not found")
+	// ErrProductVersionConflict means the product changed since the caller read it
+	ErrProductVersionConflict = errors.New("product was modified by someone else")
+	// ErrStockBelowReserved means the new stock would not cover stock reserved by pending orders
+	ErrStockBelowReserved = errors.New("stock cannot be lower than the stock reserved by pending orders")
+	// ErrInvalidCursor means a page cursor was not issued for the requested sort
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Sort orders of GET /products
+const (
+	ProductSortNewest    = "newest"
+	ProductSortPriceAsc  = "price_asc"
+	ProductSortPriceDesc = "price_desc"
+	ProductSortName      = "name"
+)
+
 // Product structure
 type Product struct {
-	ID          string    `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	Price       float64   `json:"price" db:"price"`
-	Stock       int       `json:"stock" db:"stock"`
-	Category    string    `json:"category" db:"category"`
-	CreatedDate time.Time `json:"created_date" db:"created_at"`
-	UpdatedDate time.Time `json:"updated_date" db:"updated_at"`
+	ID            string     `json:"id" db:"id"`
+	Name          string     `json:"name" db:"name"`
+	Description   string     `json:"description" db:"description"`
+	Price         float64    `json:"price" db:"price"`
+	Stock         int        `json:"stock" db:"stock"`
+	ReservedStock int        `json:"reserved_stock" db:"reserved_stock"` // held by pending orders
+	Category      string     `json:"category" db:"category"`
+	Version       int        `json:"version" db:"version"` // incremented on every catalogue edit and stock movement, sent as the ETag
+	CreatedDate   time.Time  `json:"created_date" db:"created_at"`
+	UpdatedDate   time.Time  `json:"updated_date" db:"updated_at"`
+	DeletedDate   *time.Time `json:"deleted_date,omitempty" db:"deleted_date"` // soft deleted, kept for order_items
+}
+
+// AvailableStock is the stock that is not reserved by pending orders
+func (p *Product) AvailableStock() int {
+	return p.Stock - p.ReservedStock
+}
+
+// StockChange is a product whose stock moved outside of a catalogue edit, with the
+// product as it was before
+type StockChange struct {
+	Product  *Product
+	Previous *Product
+}
+
+// ProductFilter narrows down and orders the products returned by GET /products
+type ProductFilter struct {
+	Query    string // free text matched against name and description
+	Category string
+	MinPrice *float64 // inclusive
+	MaxPrice *float64 // inclusive
+	InStock  bool     // only products with unreserved stock
+	Sort     string   // one of the ProductSort constants
+	Cursor   string
+	Limit    int
+}
+
+// ProductPage is a page of products, Total counts every product matching the filter
+type ProductPage struct {
+	Products   []*Product
+	Total      int
+	NextCursor string
+}
+
+// ProductRequest is the body of POST /products and PUT /products/{id}
+type ProductRequest struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	Stock       int     `json:"stock"`
+	Category    string  `json:"category"`
+}
+
+// ProductPatch is the body of PATCH /products/{id}, only the given fields change
+type ProductPatch struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Price       *float64 `json:"price"`
+	Stock       *int     `json:"stock"`
+	Category    *string  `json:"category"`
+}
+
+// ProductResponse is a product as returned by the API
+type ProductResponse struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Price       float64   `json:"price"`
+	Stock       int       `json:"stock"`
+	Category    string    `json:"category"`
+	Version     int       `json:"version"`
+	CreatedDate time.Time `json:"created_date"`
+	UpdatedDate time.Time `json:"updated_date"`
+}
+
+func NewProductResponse(product *Product) *ProductResponse {
+	return &ProductResponse{
+		ID:          product.ID,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+		Stock:       product.Stock,
+		Category:    product.Category,
+		Version:     product.Version,
+		CreatedDate: product.CreatedDate,
+		UpdatedDate: product.UpdatedDate,
+	}
+}
+
+// ProductPageResponse is a page of products as returned by GET /products
+type ProductPageResponse struct {
+	Products   []*ProductResponse `json:"products"`
+	Total      int                `json:"total"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+func NewProductPageResponse(page *ProductPage) *ProductPageResponse {
+	response := &ProductPageResponse{
+		Products:   make([]*ProductResponse, 0, len(page.Products)),
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	}
+	for _, product := range page.Products {
+		response.Products = append(response.Products, NewProductResponse(product))
+	}
+	return response
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SalesReport summarises the orders placed in a period
+type SalesReport struct {
+	From        time.Time             `json:"from"`
+	To          time.Time             `json:"to"`
+	TotalOrders int                   `json:"total_orders"`
+	Statuses    []*OrderStatusSummary `json:"statuses"`
+}
+
+type OrderStatusSummary struct {
+	Status     string  `json:"status"`
+	Orders     int     `json:"orders"`
+	TotalPrice float64 `json:"total_price"`
+}
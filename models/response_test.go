@@ -0,0 +1,149 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// passwordHash is a bcrypt hash as stored in users.password
+const passwordHash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+
+// assertNoPasswordHash fails the test when the JSON encoding of v has a password key
+// at any depth or carries a bcrypt hash
+func assertNoPasswordHash(t *testing.T, v interface{}) {
+	t.Helper()
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unable to encode %T, err : %s", v, err)
+	}
+	if strings.Contains(string(body), "$2") {
+		t.Errorf("%T encodes a bcrypt hash: %s", v, body)
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("unable to decode %T, err : %s", v, err)
+	}
+	if path, ok := findPasswordKey(decoded, ""); ok {
+		t.Errorf("%T encodes a password key at %s: %s", v, path, body)
+	}
+}
+
+func findPasswordKey(value interface{}, path string) (string, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if strings.Contains(strings.ToLower(key), "password") {
+				return path + "." + key, true
+			}
+			if found, ok := findPasswordKey(child, path+"."+key); ok {
+				return found, true
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if found, ok := findPasswordKey(child, path+"[]"); ok {
+				return found, true
+			}
+		}
+	}
+	return "", false
+}
+
+func testUser() *User {
+	return &User{
+		ID:           "user-1",
+		FirstName:    "Ada",
+		LastName:     "Lovelace",
+		Email:        "ada@example.com",
+		PendingEmail: "ada@example.org",
+		Password:     passwordHash,
+		Role:         RoleCustomer,
+		CreatedDate:  time.Now(),
+		UpdatedDate:  time.Now(),
+	}
+}
+
+func TestUserEncodingOmitsPassword(t *testing.T) {
+	assertNoPasswordHash(t, testUser())
+	assertNoPasswordHash(t, []*User{testUser()})
+}
+
+func TestUserResponseOmitsPassword(t *testing.T) {
+	response := NewUserResponse(testUser())
+	if response.Email != "ada@example.com" {
+		t.Fatalf("expected email ada@example.com, got %s", response.Email)
+	}
+
+	assertNoPasswordHash(t, response)
+}
+
+func TestResponsesOmitPassword(t *testing.T) {
+	now := time.Now()
+	address := PostalAddress{
+		FullName:   "Ada Lovelace",
+		Line1:      "12 St James's Square",
+		City:       "London",
+		PostalCode: "SW1Y 4JH",
+		Country:    "GB",
+	}
+	order := &Order{
+		ID:              "order-1",
+		UserID:          "user-1",
+		TotalPrice:      42,
+		Status:          OrderStatusPaid,
+		CreatedDate:     now,
+		Items:           []*OrderItem{{ID: "item-1", ProductID: "product-1", ProductName: "Notebook", UnitPrice: 21, Quantity: 2}},
+		ShippingAddress: &address,
+	}
+	product := &Product{
+		ID:          "product-1",
+		Name:        "Notebook",
+		Price:       21,
+		Stock:       5,
+		Category:    "stationery",
+		Version:     3,
+		CreatedDate: now,
+		UpdatedDate: now,
+	}
+	wishlistItems := []*WishlistItem{{ProductID: "product-1", Name: "Notebook", Price: 21, InStock: true, AddedDate: now}}
+
+	responses := map[string]interface{}{
+		"address": NewAddressResponse(&Address{ID: "address-1", UserID: "user-1", PostalAddress: address}),
+		"cart": NewCartResponse("user-1", 1, []*ProductDetails{
+			{ID: "product-1", Name: "Notebook", Price: 21, Quantity: 2, PriceAtAdd: 20},
+		}),
+		"order":           NewOrderResponse(order),
+		"order page":      NewOrderPageResponse(&OrderPage{Orders: []*Order{order}, NextCursor: "next"}),
+		"payment":         NewPaymentResponse(&PaymentDetails{ID: "payment-1", OrderID: "order-1", PaymentStatus: PaymentStatusSuccess, Amount: 42}),
+		"product":         NewProductResponse(product),
+		"product page":    NewProductPageResponse(&ProductPage{Products: []*Product{product}, Total: 1}),
+		"product search":  &ProductSearchResponse{Products: []*ProductResponse{NewProductResponse(product)}, Total: 1},
+		"wishlist":        NewWishlistResponse(&Wishlist{ID: "wishlist-1", UserID: "user-1", Name: "Gifts"}, "https://shop.example.com/wishlists/shared/token", wishlistItems),
+		"shared wishlist": &SharedWishlistResponse{Name: "Gifts", Items: wishlistItems},
+	}
+
+	for name, response := range responses {
+		t.Run(name, func(t *testing.T) {
+			assertNoPasswordHash(t, response)
+		})
+	}
+}
+
+func TestAssertNoPasswordHashDetectsHash(t *testing.T) {
+	// The request body carries the password, it is what the check must catch
+	request := &CreateUserRequest{Email: "ada@example.com", Password: passwordHash}
+
+	body, _ := json.Marshal(request)
+	var decoded interface{}
+	json.Unmarshal(body, &decoded)
+	if _, ok := findPasswordKey(decoded, ""); !ok {
+		t.Fatalf("expected a password key in %s", body)
+	}
+	if !strings.Contains(string(body), "$2") {
+		t.Fatalf("expected a bcrypt hash in %s", body)
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+// FacetCount is the number of search results with a given value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// PriceBucketCount is the number of search results priced from Min up to, but not
+// including, Max. The last bucket has no Max.
+type PriceBucketCount struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max,omitempty"`
+	Count int      `json:"count"`
+}
+
+// SearchFacets are counted over the results of the text query. The category counts
+// ignore the category filter and the price counts ignore the price filter, so that
+// other choices can be shown next to the selected one.
+type SearchFacets struct {
+	Categories []FacetCount       `json:"categories"`
+	Prices     []PriceBucketCount `json:"prices"`
+}
+
+// ProductSearchResponse is the result of GET /products/search, most relevant first
+type ProductSearchResponse struct {
+	Products   []*ProductResponse `json:"products"`
+	Total      int                `json:"total"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	Facets     SearchFacets       `json:"facets"`
+}
+
+// ProductSuggestion is an autocomplete entry of GET /products/suggest
+type ProductSuggestion struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Events written to security_audit_log
+const (
+	AuditEventAccountLocked   = "account_locked"
+	AuditEventIPLocked        = "ip_locked"
+	AuditEventAccountUnlocked = "account_unlocked"
+	AuditEventTwoFactorOn     = "two_factor_enabled"
+	AuditEventTwoFactorOff    = "two_factor_disabled"
+	AuditEventRecoveryCode    = "recovery_code_used"
+	AuditEventAccountDeleted  = "account_deleted"
+)
+
+// LoginAttempt tracks failed logins for one account ("account:<email>") or one
+// client address ("ip:<address>")
+type LoginAttempt struct {
+	Key            string     `json:"key" db:"attempt_key"`
+	Failures       int        `json:"failures" db:"failures"`
+	LastFailedDate time.Time  `json:"last_failed_date" db:"last_failed_date"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+type SecurityAuditLog struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"user_id,omitempty" db:"user_id"`
+	Event       string    `json:"event" db:"event"`
+	Detail      string    `json:"detail" db:"detail"`
+	IPAddress   string    `json:"ip_address" db:"ip_address"`
+	CreatedDate time.Time `json:"created_date" db:"created_date"`
+}
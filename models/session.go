@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Session groups the refresh tokens issued from one login
+type Session struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	TwoFactor   bool       `json:"two_factor" db:"two_factor"` // login completed a second factor
+	CreatedDate time.Time  `json:"created_date" db:"created_date"`
+	RevokedDate *time.Time `json:"revoked_date,omitempty" db:"revoked_date"`
+}
+
+// RefreshToken is stored hashed, the plain token is only ever returned to the client
+type RefreshToken struct {
+	ID          string     `json:"id" db:"id"`
+	SessionID   string     `json:"session_id" db:"session_id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	TokenHash   string     `json:"-" db:"token_hash"`
+	ExpiresDate time.Time  `json:"expires_date" db:"expires_date"`
+	UsedDate    *time.Time `json:"used_date,omitempty" db:"used_date"`
+	CreatedDate time.Time  `json:"created_date" db:"created_date"`
+}
+
+// TwoFactorChallenge is returned by login instead of a TokenPair when the user has
+// two-factor authentication enabled. Token is exchanged together with a code at
+// /users/login/2fa.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	Token             string `json:"two_factor_token"`
+	ExpiresIn         int    `json:"expires_in"` // lifetime of Token in seconds
+}
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // lifetime of Token in seconds
+}
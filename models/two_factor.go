@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// TwoFactor is a user's TOTP enrollment. It only protects logins once EnabledDate is
+// set, which happens after the user confirmed a code from their authenticator app.
+type TwoFactor struct {
+	UserID          string     `json:"user_id" db:"user_id"`
+	SecretEncrypted string     `json:"-" db:"secret_encrypted"`
+	EnabledDate     *time.Time `json:"enabled_date,omitempty" db:"enabled_date"`
+	LastUsedStep    int64      `json:"-" db:"last_used_step"` // TOTP step of the last accepted code
+	CreatedDate     time.Time  `json:"created_date" db:"created_date"`
+}
+
+func (t *TwoFactor) Enabled() bool {
+	return t != nil && t.EnabledDate != nil
+}
+
+// RecoveryCode lets a user log in without their authenticator app, once. Only its
+// bcrypt hash is stored.
+type RecoveryCode struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	CodeHash    string     `json:"-" db:"code_hash"`
+	UsedDate    *time.Time `json:"used_date,omitempty" db:"used_date"`
+	CreatedDate time.Time  `json:"created_date" db:"created_date"`
+}
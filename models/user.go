@@ -4,14 +4,58 @@ import (
 	"time"
 )
 
+// User roles
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
 // User structure
 type User struct {
-	ID          string    `json:"id" db:"id"`
-	FirstName   string    `json:"first_name" db:"first_name"`
-	LastName    string    `json:"last_name" db:"last_name"`
-	Email       string    `json:"email" db:"email"`
-	Password    string    `json:"password" db:"password"`
-	Role        string    `json:"role,omitempty" db:"role"` // Example: "customer", "admin"
-	CreatedDate time.Time `json:"created_date,omitempty" db:"created_date"`
-	UpdatedDate time.Time `json:"updated_date,omitempty" db:"updated_date"`
+	ID                  string    `json:"id" db:"id"`
+	FirstName           string    `json:"first_name" db:"first_name"`
+	LastName            string    `json:"last_name" db:"last_name"`
+	Email               string    `json:"email" db:"email"`
+	PendingEmail        string    `json:"pending_email,omitempty" db:"pending_email"` // awaiting verification, see dao.SetPendingEmail
+	Password            string    `json:"-" db:"password"`                            // bcrypt hash, never serialised
+	Role                string    `json:"role,omitempty" db:"role"`                   // RoleCustomer or RoleAdmin
+	EmailVerified       bool      `json:"email_verified" db:"email_verified"`
+	CartRemindersOptOut bool      `json:"cart_reminders_opt_out" db:"cart_reminders_opt_out"` // no abandoned cart emails
+	CreatedDate         time.Time `json:"created_date,omitempty" db:"created_date"`
+	UpdatedDate         time.Time `json:"updated_date,omitempty" db:"updated_date"`
+}
+
+// CreateUserRequest is the body of POST /users
+type CreateUserRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+}
+
+// UserResponse is a user as returned by the API
+type UserResponse struct {
+	ID            string    `json:"id"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	Email         string    `json:"email"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"email_verified"`
+	CartReminders bool      `json:"cart_reminders"` // abandoned cart reminder emails are wanted
+	CreatedDate   time.Time `json:"created_date"`
+}
+
+func NewUserResponse(user *User) *UserResponse {
+	return &UserResponse{
+		ID:            user.ID,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Email:         user.Email,
+		PendingEmail:  user.PendingEmail,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		CartReminders: !user.CartRemindersOptOut,
+		CreatedDate:   user.CreatedDate,
+	}
 }
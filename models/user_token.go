@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Purposes of single use tokens. All but TokenPurposeTwoFactorLogin are sent to a
+// user's email address; that one is returned by the first step of a two-step login.
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeEmailChange       = "email_change"
+	TokenPurposeAccountUnlock     = "account_unlock"
+	TokenPurposeTwoFactorLogin    = "two_factor_login"
+)
+
+// UserToken is a single use, expiring token. Only its SHA-256 hash is stored.
+type UserToken struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	Purpose     string     `json:"purpose" db:"purpose"`
+	TokenHash   string     `json:"-" db:"token_hash"`
+	ExpiresDate time.Time  `json:"expires_date" db:"expires_date"`
+	UsedDate    *time.Time `json:"used_date,omitempty" db:"used_date"`
+	CreatedDate time.Time  `json:"created_date" db:"created_date"`
+}
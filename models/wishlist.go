@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrWishlistNotFound     = errors.New("wishlist not found")
+	ErrWishlistItemNotFound = errors.New("product is not in the wishlist")
+	ErrSavedItemNotFound    = errors.New("product is not saved for later")
+)
+
+// Wishlist is a named list of products a user is interested in. Anyone with the
+// share link of a shared list can view it.
+type Wishlist struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"user_id" db:"user_id"`
+	Name        string    `json:"name" db:"name"`
+	ShareToken  string    `json:"-" db:"share_token"` // empty unless the list is shared
+	ItemCount   int       `json:"item_count" db:"-"`
+	CreatedDate time.Time `json:"created_date" db:"created_date"`
+	UpdatedDate time.Time `json:"updated_date" db:"updated_date"`
+}
+
+// WishlistItem is a product on a wishlist with its current catalogue state
+type WishlistItem struct {
+	ProductID string    `json:"product_id"`
+	Name      string    `json:"name"`
+	Price     float64   `json:"price"`
+	InStock   bool      `json:"in_stock"`
+	AddedDate time.Time `json:"added_date"`
+}
+
+// SavedItem is a cart line the user saved for later, it keeps its quantity for when
+// it is moved back to the cart
+type SavedItem struct {
+	ProductID string    `json:"product_id"`
+	Name      string    `json:"name"`
+	Price     float64   `json:"price"`
+	Quantity  int       `json:"quantity"`
+	InStock   bool      `json:"in_stock"`
+	SavedDate time.Time `json:"saved_date"`
+}
+
+// WishlistRequest is the body of POST and PATCH /users/me/wishlists
+type WishlistRequest struct {
+	Name string `json:"name"`
+}
+
+// WishlistItemRequest is the body of POST /users/me/wishlists/{id}/items
+type WishlistItemRequest struct {
+	ProductID string `json:"product_id"`
+}
+
+// WishlistResponse is a wishlist as returned to its owner, items are only included
+// when a single list is fetched
+type WishlistResponse struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Shared      bool            `json:"shared"`
+	ShareURL    string          `json:"share_url,omitempty"`
+	ItemCount   int             `json:"item_count"`
+	Items       []*WishlistItem `json:"items,omitempty"`
+	CreatedDate time.Time       `json:"created_date"`
+	UpdatedDate time.Time       `json:"updated_date"`
+}
+
+func NewWishlistResponse(wishlist *Wishlist, shareURL string, items []*WishlistItem) *WishlistResponse {
+	response := &WishlistResponse{
+		ID:          wishlist.ID,
+		Name:        wishlist.Name,
+		Shared:      wishlist.ShareToken != "",
+		ShareURL:    shareURL,
+		ItemCount:   wishlist.ItemCount,
+		Items:       items,
+		CreatedDate: wishlist.CreatedDate,
+		UpdatedDate: wishlist.UpdatedDate,
+	}
+	if items != nil {
+		response.ItemCount = len(items)
+	}
+	return response
+}
+
+// SharedWishlistResponse is a shared wishlist as seen through its share link, without
+// anything about its owner
+type SharedWishlistResponse struct {
+	Name  string          `json:"name"`
+	Items []*WishlistItem `json:"items"`
+}
@@ -3,6 +3,7 @@ package notifications
 import (
 	"ecommerce/models"
 	"fmt"
+	"strings"
 
 	"github.com/wneessen/go-mail"
 )
@@ -27,8 +28,9 @@ func NewEmailConfig(port int, smtpHost, userName, password, from string) *EmailC
 }
 
 type EmaiMetadata struct {
-	To   string
-	Body string
+	To      string
+	Subject string
+	Body    string
 }
 
 type NotificationMetadata struct {
@@ -44,7 +46,7 @@ func (e *EmailConfig) sendEmail(emailMetadata *EmaiMetadata) error {
 	m := mail.NewMsg()
 	m.From(e.From)
 	m.To(emailMetadata.To)
-	m.Subject("Order Status")
+	m.Subject(emailMetadata.Subject)
 	m.SetBodyString("text/plain", emailMetadata.Body)
 
 	// Configure and send
@@ -65,33 +67,75 @@ func (e *EmailConfig) sendEmail(emailMetadata *EmaiMetadata) error {
 }
 
 func (e *EmailConfig) NotifyOrderStatus(OrderDetails *models.OrderDetails, status string) error {
+	return e.notifyOrder(OrderDetails, status, orderStatusMessage(status), "Order Status")
+}
+
+// NotifyOrderExpired tells a customer that their unpaid order was canceled when its
+// stock reservation ran out
+func (e *EmailConfig) NotifyOrderExpired(OrderDetails *models.OrderDetails) error {
+	message := "Your order has been canceled because we did not receive your payment in time.\nThe items are no longer reserved for you, you are welcome to order them again."
+	return e.notifyOrder(OrderDetails, models.OrderStatusCanceled, message, "Order Expired")
+}
+
+func (e *EmailConfig) notifyOrder(OrderDetails *models.OrderDetails, status, message, subject string) error {
 	body := fmt.Sprintf(`
 Hi %s,
 
-Your order has been placed successfully! 
-Thank you for shopping with us.
+%s
 
 Your order details:
 	Order ID: %s
 	Order Status: %s
 	Total Price: Rs %.2f
-
-We will notify you once your order is processed and shipped.
+%s
 If you have any queries, feel free to contact us.
 
 Best regards,
 Your E-Commerce Team
-	`, OrderDetails.Username, OrderDetails.ID, status, OrderDetails.TotalPrice)
+	`, OrderDetails.Username, message, OrderDetails.ID, status, OrderDetails.TotalPrice, shippingAddressBlock(OrderDetails.ShippingAddress))
 
 	emailMetadata := EmaiMetadata{
-		To:   OrderDetails.Email,
-		Body: body,
+		To:      OrderDetails.Email,
+		Subject: subject,
+		Body:    body,
 	}
 
 	err := e.sendEmail(&emailMetadata)
 	return err
 }
 
+// shippingAddressBlock formats the address an order ships to for order emails
+func shippingAddressBlock(address *models.PostalAddress) string {
+	if address == nil || address.Line1 == "" {
+		return ""
+	}
+
+	lines := []string{address.FullName, address.Line1}
+	if address.Line2 != "" {
+		lines = append(lines, address.Line2)
+	}
+	lines = append(lines, strings.TrimSpace(address.City+" "+address.PostalCode), strings.TrimSpace(address.State+" "+address.Country))
+	if address.Phone != "" {
+		lines = append(lines, "Phone: "+address.Phone)
+	}
+	return "\nShipping to:\n\t" + strings.Join(lines, "\n\t") + "\n"
+}
+
+func orderStatusMessage(status string) string {
+	switch status {
+	case models.OrderStatusPaid:
+		return "Your order has been placed successfully!\nThank you for shopping with us. We will notify you once your order is processed and shipped."
+	case models.OrderStatusCanceled:
+		return "Your order has been canceled. Any amount paid will be refunded to your original payment method."
+	case models.OrderStatusShipped:
+		return "Good news, your order is on its way!"
+	case models.OrderStatusDelivered:
+		return "Your order has been delivered. We hope you enjoy it!"
+	default:
+		return "The status of your order has been updated."
+	}
+}
+
 func (e *EmailConfig) NotifyUserCreated(userInfo *models.User) error {
 	body := fmt.Sprintf(`
 Hi %s,
@@ -111,8 +155,160 @@ Ecommerce Team
 		`, userInfo.FirstName, userInfo.Email)
 
 	emaiMetadata := EmaiMetadata{
-		To:   userInfo.Email,
-		Body: body,
+		To:      userInfo.Email,
+		Subject: "Welcome to Ecommerce",
+		Body:    body,
+	}
+
+	err := e.sendEmail(&emaiMetadata)
+	return err
+}
+
+func (e *EmailConfig) NotifyEmailVerification(userInfo *models.User, link string) error {
+	body := fmt.Sprintf(`
+Hi %s,
+
+Please confirm that %s is your email address by opening the link below:
+
+%s
+
+The link expires soon and can only be used once. If you did not create an account, you can ignore this email.
+
+Best regards,
+Ecommerce Team
+		`, userInfo.FirstName, userInfo.Email, link)
+
+	emaiMetadata := EmaiMetadata{
+		To:      userInfo.Email,
+		Subject: "Verify your email address",
+		Body:    body,
+	}
+
+	err := e.sendEmail(&emaiMetadata)
+	return err
+}
+
+func (e *EmailConfig) NotifyPasswordReset(userInfo *models.User, link string) error {
+	body := fmt.Sprintf(`
+Hi %s,
+
+We received a request to reset the password of your account. You can choose a new password here:
+
+%s
+
+The link expires soon and can only be used once. If you did not ask for a reset, you can ignore this email, your password has not been changed.
+
+Best regards,
+Ecommerce Team
+		`, userInfo.FirstName, link)
+
+	emaiMetadata := EmaiMetadata{
+		To:      userInfo.Email,
+		Subject: "Reset your password",
+		Body:    body,
+	}
+
+	err := e.sendEmail(&emaiMetadata)
+	return err
+}
+
+func (e *EmailConfig) NotifyAccountLocked(userInfo *models.User, link string) error {
+	body := fmt.Sprintf(`
+Hi %s,
+
+Your account has been temporarily locked after several failed login attempts.
+
+If this was you, you can unlock your account right away here:
+
+%s
+
+If it was not you, someone may be trying to guess your password. We recommend resetting it.
+
+Best regards,
+Ecommerce Team
+		`, userInfo.FirstName, link)
+
+	emaiMetadata := EmaiMetadata{
+		To:      userInfo.Email,
+		Subject: "Your account has been locked",
+		Body:    body,
+	}
+
+	err := e.sendEmail(&emaiMetadata)
+	return err
+}
+
+// NotifyWishlistProduct tells a user that a product on one of their wishlists is back
+// in stock, cheaper than before, or both. previousPrice is nil when the price did not drop.
+func (e *EmailConfig) NotifyWishlistProduct(userInfo *models.User, product *models.Product, previousPrice *float64, backInStock bool) error {
+	var news []string
+	subject := fmt.Sprintf("%s is back in stock", product.Name)
+	if backInStock {
+		news = append(news, fmt.Sprintf("%s is back in stock.", product.Name))
+	}
+	if previousPrice != nil {
+		news = append(news, fmt.Sprintf("The price of %s dropped from Rs %.2f to Rs %.2f.", product.Name, *previousPrice, product.Price))
+		if !backInStock {
+			subject = fmt.Sprintf("Price drop on %s", product.Name)
+		}
+	}
+
+	body := fmt.Sprintf(`
+Hi %s,
+
+Good news about a product on your wishlist:
+
+%s
+
+Stock is limited, so don't wait too long.
+
+Best regards,
+Ecommerce Team
+		`, userInfo.FirstName, strings.Join(news, "\n"))
+
+	emaiMetadata := EmaiMetadata{
+		To:      userInfo.Email,
+		Subject: subject,
+		Body:    body,
+	}
+
+	err := e.sendEmail(&emaiMetadata)
+	return err
+}
+
+// NotifyAbandonedCart reminds a user of the items left in their cart. restoreLink puts
+// the items back in the cart, unsubscribeLink stops further reminders.
+func (e *EmailConfig) NotifyAbandonedCart(userInfo *models.User, items []*models.ProductDetails, subtotal float32, restoreLink, unsubscribeLink string) error {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, fmt.Sprintf("- %s x %d: Rs %.2f", item.Name, item.Quantity, item.LineTotal()))
+	}
+
+	body := fmt.Sprintf(`
+Hi %s,
+
+You left these items in your cart:
+
+%s
+
+Subtotal: Rs %.2f
+
+Pick up where you left off, this link puts them back in your cart:
+%s
+
+Prices and stock may have changed since you added them.
+
+Don't want these reminders? Unsubscribe here:
+%s
+
+Best regards,
+Ecommerce Team
+		`, userInfo.FirstName, strings.Join(lines, "\n"), subtotal, restoreLink, unsubscribeLink)
+
+	emaiMetadata := EmaiMetadata{
+		To:      userInfo.Email,
+		Subject: "You left something in your cart",
+		Body:    body,
 	}
 
 	err := e.sendEmail(&emaiMetadata)
@@ -3,35 +3,94 @@ package routes
 import (
 	"ecommerce/handlers"
 	"ecommerce/middleware"
+	"ecommerce/models"
 
 	"github.com/gorilla/mux"
 )
 
-func SetupRoutes(payment *handlers.Payment, user *handlers.User) *mux.Router {
+func SetupRoutes(payment *handlers.Payment, user *handlers.User, order *handlers.Order, product *handlers.Product, cart *handlers.Cart, wishlist *handlers.Wishlist) *mux.Router {
 	router := mux.NewRouter()
 
+	// Public keys for verifying tokens
+	router.HandleFunc("/.well-known/jwks.json", handlers.JWKS).Methods("GET")
+
 	// User routes
 	router.HandleFunc("/users", user.CreateUser).Methods("POST")
-	router.HandleFunc("/users/login", handlers.Login).Methods("POST")
+	router.HandleFunc("/users/login", user.Login).Methods("POST")
+	router.HandleFunc("/users/login/2fa", user.LoginTwoFactor).Methods("POST")
+	router.HandleFunc("/users/unlock", user.UnlockAccount).Methods("POST")
+	router.HandleFunc("/users/token/refresh", handlers.RefreshToken).Methods("POST")
+	router.HandleFunc("/users/logout", middleware.AuthMiddleware(handlers.Logout)).Methods("POST")
+	router.HandleFunc("/users/logout/all", middleware.AuthMiddleware(handlers.LogoutAll)).Methods("POST")
+	router.HandleFunc("/users/password/forgot", user.ForgotPassword).Methods("POST")
+	router.HandleFunc("/users/password/reset", user.ResetPassword).Methods("POST")
+	router.HandleFunc("/users/email/verify", user.VerifyEmail).Methods("POST")
+	router.HandleFunc("/users/email/confirm", user.ConfirmEmailChange).Methods("POST")
+	router.HandleFunc("/users/cart-reminders/unsubscribe", handlers.UnsubscribeCartReminders).Methods("POST")
+	router.HandleFunc("/users/email/verification", middleware.AuthMiddleware(user.ResendEmailVerification)).Methods("POST")
+	router.HandleFunc("/users/me", middleware.AuthMiddleware(handlers.GetCurrentUser)).Methods("GET")
+	router.HandleFunc("/users/me", middleware.AuthMiddleware(user.UpdateCurrentUser)).Methods("PATCH")
+	router.HandleFunc("/users/me", middleware.AuthMiddleware(user.DeleteCurrentUser)).Methods("DELETE")
+	router.HandleFunc("/users/me/password", middleware.AuthMiddleware(user.ChangePassword)).Methods("POST")
+	router.HandleFunc("/users/me/addresses", middleware.AuthMiddleware(handlers.GetAddresses)).Methods("GET")
+	router.HandleFunc("/users/me/addresses", middleware.AuthMiddleware(handlers.CreateAddress)).Methods("POST")
+	router.HandleFunc("/users/me/addresses/{id}", middleware.AuthMiddleware(handlers.GetAddress)).Methods("GET")
+	router.HandleFunc("/users/me/addresses/{id}", middleware.AuthMiddleware(handlers.UpdateAddress)).Methods("PUT")
+	router.HandleFunc("/users/me/addresses/{id}", middleware.AuthMiddleware(handlers.DeleteAddress)).Methods("DELETE")
+	router.HandleFunc("/users/me/2fa/setup", middleware.AuthMiddleware(user.SetupTwoFactor)).Methods("POST")
+	router.HandleFunc("/users/me/2fa/verify", middleware.AuthMiddleware(user.VerifyTwoFactor)).Methods("POST")
+	router.HandleFunc("/users/me/2fa/disable", middleware.AuthMiddleware(user.DisableTwoFactor)).Methods("POST")
 	router.HandleFunc("/users/{id}", middleware.AuthMiddleware(handlers.GetUser)).Methods("GET")
 
 	// // Product routes
-	router.HandleFunc("/products", handlers.CreateProduct).Methods("POST")
+	router.HandleFunc("/products", middleware.AuthMiddleware(middleware.RequireRole(product.CreateProduct, models.RoleAdmin))).Methods("POST")
 	router.HandleFunc("/products", handlers.GetProducts).Methods("GET")
+	router.HandleFunc("/products/search", product.SearchProducts).Methods("GET")
+	router.HandleFunc("/products/suggest", product.SuggestProducts).Methods("GET")
+	router.HandleFunc("/products/{id}", handlers.GetProduct).Methods("GET")
+	router.HandleFunc("/products/{id}", middleware.AuthMiddleware(middleware.RequireRole(product.UpdateProduct, models.RoleAdmin))).Methods("PUT")
+	router.HandleFunc("/products/{id}", middleware.AuthMiddleware(middleware.RequireRole(product.PatchProduct, models.RoleAdmin))).Methods("PATCH")
+	router.HandleFunc("/products/{id}", middleware.AuthMiddleware(middleware.RequireRole(product.DeleteProduct, models.RoleAdmin))).Methods("DELETE")
+
+	// // Cart routes, guests identify their cart with the X-Cart-Token header
+	router.HandleFunc("/cart", middleware.OptionalAuthMiddleware(cart.AddToCart)).Methods("POST")
+	router.HandleFunc("/cart", middleware.OptionalAuthMiddleware(cart.GetCartItems)).Methods("GET")
+	router.HandleFunc("/cart", middleware.OptionalAuthMiddleware(cart.ClearCart)).Methods("DELETE")
+	router.HandleFunc("/cart/items/{productID}", middleware.OptionalAuthMiddleware(cart.UpdateCartItem)).Methods("PUT")
+	router.HandleFunc("/cart/items/{productID}", middleware.OptionalAuthMiddleware(cart.RemoveCartItem)).Methods("DELETE")
+	router.HandleFunc("/cart/restore", cart.RestoreCart).Methods("POST")
+	router.HandleFunc("/cart/items/{productID}/save", middleware.AuthMiddleware(cart.SaveForLater)).Methods("POST")
+	router.HandleFunc("/cart/saved", middleware.AuthMiddleware(cart.GetSavedItems)).Methods("GET")
+	router.HandleFunc("/cart/saved/{productID}/move", middleware.AuthMiddleware(cart.MoveToCart)).Methods("POST")
+	router.HandleFunc("/cart/saved/{productID}", middleware.AuthMiddleware(cart.RemoveSavedItem)).Methods("DELETE")
 
-	// // Cart routes
-	router.HandleFunc("/cart", middleware.AuthMiddleware(handlers.AddToCart)).Methods("POST")
-	router.HandleFunc("/cart/{userID}", middleware.AuthMiddleware(handlers.GetCartItems)).Methods("GET")
+	// Wishlist routes, shared lists are public
+	router.HandleFunc("/users/me/wishlists", middleware.AuthMiddleware(wishlist.GetWishlists)).Methods("GET")
+	router.HandleFunc("/users/me/wishlists", middleware.AuthMiddleware(wishlist.CreateWishlist)).Methods("POST")
+	router.HandleFunc("/users/me/wishlists/{id}", middleware.AuthMiddleware(wishlist.GetWishlist)).Methods("GET")
+	router.HandleFunc("/users/me/wishlists/{id}", middleware.AuthMiddleware(wishlist.RenameWishlist)).Methods("PATCH")
+	router.HandleFunc("/users/me/wishlists/{id}", middleware.AuthMiddleware(wishlist.DeleteWishlist)).Methods("DELETE")
+	router.HandleFunc("/users/me/wishlists/{id}/items", middleware.AuthMiddleware(wishlist.AddWishlistItem)).Methods("POST")
+	router.HandleFunc("/users/me/wishlists/{id}/items/{productID}", middleware.AuthMiddleware(wishlist.RemoveWishlistItem)).Methods("DELETE")
+	router.HandleFunc("/users/me/wishlists/{id}/share", middleware.AuthMiddleware(wishlist.ShareWishlist)).Methods("POST")
+	router.HandleFunc("/users/me/wishlists/{id}/share", middleware.AuthMiddleware(wishlist.UnshareWishlist)).Methods("DELETE")
+	router.HandleFunc("/wishlists/shared/{token}", wishlist.GetSharedWishlist).Methods("GET")
 
 	// // Order routes
-	router.HandleFunc("/orders", middleware.AuthMiddleware(handlers.CreateOrder)).Methods("POST")
-	// router.HandleFunc("/orders", middleware.AuthMiddleware(handlers.GetOrders)).Methods("GET")
+	router.HandleFunc("/orders", middleware.AuthMiddleware(order.CreateOrder)).Methods("POST")
+	router.HandleFunc("/orders", middleware.AuthMiddleware(handlers.GetOrders)).Methods("GET")
+	router.HandleFunc("/orders/{id}", middleware.AuthMiddleware(handlers.GetOrder)).Methods("GET")
+	router.HandleFunc("/orders/{id}/cancel", middleware.AuthMiddleware(order.CancelOrder)).Methods("POST")
+	router.HandleFunc("/orders/{id}/status", middleware.AuthMiddleware(middleware.RequireRole(order.UpdateOrderStatus, models.RoleAdmin))).Methods("PATCH")
+	router.HandleFunc("/orders/{id}/history", middleware.AuthMiddleware(handlers.GetOrderStatusHistory)).Methods("GET")
+	router.HandleFunc("/orders/{id}/payments", middleware.AuthMiddleware(handlers.GetOrderPayments)).Methods("GET")
 
 	// Payment routes
-	router.HandleFunc("/payments/initiate", middleware.AuthMiddleware(handlers.InitiatePayment)).Methods("POST")
+	router.HandleFunc("/payments/initiate", middleware.AuthMiddleware(payment.InitiatePayment)).Methods("POST")
 	router.HandleFunc("/payments/webhook", payment.PaymentWebhook).Methods("POST")
+	router.HandleFunc("/payments/{id}", middleware.AuthMiddleware(handlers.GetPayment)).Methods("GET")
 
-	// Payment gateway (This is equivalent to the payment gateway notifying your server about the payment status)
-	router.HandleFunc("/paymentGateway/notify", handlers.Notify).Methods("POST")
+	// Reporting routes
+	router.HandleFunc("/reports/sales", middleware.AuthMiddleware(middleware.RequireRole(handlers.GetSalesReport, models.RoleAdmin))).Methods("GET")
 	return router
 }
@@ -0,0 +1,236 @@
+// Package search is an in-process full-text index of the product catalogue. It is
+// loaded from the database at start up and kept fresh from product events, so no
+// external search cluster is needed.
+package search
+
+import (
+	"ecommerce/models"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fields of a product that are indexed, a match in the name counts the most
+var fieldWeights = []struct {
+	weight float64
+	text   func(*models.Product) string
+}{
+	{3, func(p *models.Product) string { return p.Name }},
+	{2, func(p *models.Product) string { return p.Category }},
+	{1, func(p *models.Product) string { return p.Description }},
+}
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// How much matches that are not exact count compared to exact ones
+const (
+	prefixFactor  = 0.8
+	typoFactor    = 0.6 // per edit
+	maxExpansions = 50  // prefix and typo variants considered per query word
+)
+
+type document struct {
+	product models.Product
+	length  float64 // weighted number of words
+}
+
+// Index is an inverted index of products. It is safe for concurrent use.
+type Index struct {
+	mu           sync.RWMutex
+	docs         map[string]*document
+	postings     map[string]map[string]float64 // term -> product ID -> weighted term frequency
+	terms        []string                      // every term, sorted, for prefix lookups
+	totalLength  float64
+	priceBuckets []float64
+}
+
+// NewIndex returns an empty index. priceBuckets are the ascending upper bounds of
+// the price facet buckets, a last bucket collects everything above them.
+func NewIndex(priceBuckets []float64) *Index {
+	bounds := append([]float64(nil), priceBuckets...)
+	sort.Float64s(bounds)
+
+	return &Index{
+		docs:         make(map[string]*document),
+		postings:     make(map[string]map[string]float64),
+		priceBuckets: bounds,
+	}
+}
+
+// Len returns the number of indexed products
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Rebuild replaces the whole index with the given products
+func (idx *Index) Rebuild(products []*models.Product) {
+	fresh := NewIndex(idx.priceBuckets)
+	for _, product := range products {
+		fresh.add(product)
+	}
+	fresh.sortTerms()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.docs, idx.postings, idx.terms, idx.totalLength = fresh.docs, fresh.postings, fresh.terms, fresh.totalLength
+}
+
+// Upsert adds a product or replaces an older indexed version of it. Deleted products
+// are removed.
+func (idx *Index) Upsert(product *models.Product) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	// Events may arrive after a rebuild already picked up a newer version
+	if doc, ok := idx.docs[product.ID]; ok && doc.product.Version > product.Version {
+		return
+	}
+
+	idx.remove(product.ID)
+	if product.DeletedDate == nil {
+		idx.add(product)
+	}
+	idx.sortTerms()
+}
+
+// Remove drops a product from the index
+func (idx *Index) Remove(productID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(productID)
+	idx.sortTerms()
+}
+
+func (idx *Index) add(product *models.Product) {
+	doc := &document{product: *product}
+	for _, field := range fieldWeights {
+		for _, term := range tokenize(field.text(product)) {
+			postings, ok := idx.postings[term]
+			if !ok {
+				postings = make(map[string]float64)
+				idx.postings[term] = postings
+			}
+			postings[product.ID] += field.weight
+			doc.length += field.weight
+		}
+	}
+	idx.docs[product.ID] = doc
+	idx.totalLength += doc.length
+}
+
+func (idx *Index) remove(productID string) {
+	doc, ok := idx.docs[productID]
+	if !ok {
+		return
+	}
+
+	for _, field := range fieldWeights {
+		for _, term := range tokenize(field.text(&doc.product)) {
+			if postings, ok := idx.postings[term]; ok {
+				delete(postings, productID)
+				if len(postings) == 0 {
+					delete(idx.postings, term)
+				}
+			}
+		}
+	}
+	delete(idx.docs, productID)
+	idx.totalLength -= doc.length
+}
+
+// sortTerms refreshes the sorted term list after the postings changed
+func (idx *Index) sortTerms() {
+	terms := make([]string, 0, len(idx.postings))
+	for term := range idx.postings {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	idx.terms = terms
+}
+
+// expand returns the indexed terms a query word matches with how much each counts.
+// An exact match always counts fully. The last word of a query is also completed as
+// a prefix, and words without an exact match are matched with typos.
+func (idx *Index) expand(word string, prefix bool) map[string]float64 {
+	variants := make(map[string]float64)
+	if _, ok := idx.postings[word]; ok {
+		variants[word] = 1
+	}
+
+	if prefix {
+		start := sort.SearchStrings(idx.terms, word)
+		for i := start; i < len(idx.terms) && strings.HasPrefix(idx.terms[i], word) && len(variants) < maxExpansions; i++ {
+			if idx.terms[i] != word {
+				variants[idx.terms[i]] = prefixFactor
+			}
+		}
+	}
+
+	if len(variants) == 0 {
+		if edits := maxEdits(word); edits > 0 {
+			for _, term := range idx.terms {
+				if distance := editDistance(word, term, edits); distance <= edits {
+					variants[term] = math.Pow(typoFactor, float64(distance))
+					if len(variants) >= maxExpansions {
+						break
+					}
+				}
+			}
+		}
+	}
+	return variants
+}
+
+// bm25 scores how well a term with the given weighted frequency describes a document
+func (idx *Index) bm25(term string, frequency, length float64) float64 {
+	n := float64(len(idx.docs))
+	df := float64(len(idx.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+	avgLength := idx.totalLength / n
+	return idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*(1-bm25B+bm25B*length/avgLength))
+}
+
+// match scores every product containing all words of text. A nil map means the text
+// has no words and every product matches.
+func (idx *Index) match(text string, prefix bool) map[string]float64 {
+	words := tokenize(text)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var scores map[string]float64
+	for i, word := range words {
+		wordScores := make(map[string]float64)
+		for term, factor := range idx.expand(word, prefix && i == len(words)-1) {
+			for productID, frequency := range idx.postings[term] {
+				score := factor * idx.bm25(term, frequency, idx.docs[productID].length)
+				if score > wordScores[productID] {
+					wordScores[productID] = score
+				}
+			}
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		// Every word has to match
+		for productID, score := range scores {
+			if wordScore, ok := wordScores[productID]; ok {
+				scores[productID] = score + wordScore
+			} else {
+				delete(scores, productID)
+			}
+		}
+	}
+	return scores
+}
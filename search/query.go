@@ -0,0 +1,141 @@
+package search
+
+import (
+	"ecommerce/models"
+	"sort"
+)
+
+// Query is a search of the catalogue
+type Query struct {
+	Text     string
+	Category string
+	MinPrice *float64 // inclusive
+	MaxPrice *float64 // inclusive
+	InStock  bool     // only products with unreserved stock
+	Offset   int
+	Limit    int
+}
+
+// Result is a page of products, most relevant first, with the facets of all matches
+type Result struct {
+	Products []*models.Product
+	Total    int
+	Facets   models.SearchFacets
+}
+
+type hit struct {
+	product *models.Product
+	score   float64
+}
+
+// Search runs a query. Words match exactly, as the prefix of a longer word when they
+// are the last word of the query, or with typos when nothing matches exactly.
+func (idx *Index) Search(query *Query) *Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	scores := idx.match(query.Text, true)
+
+	categoryCounts := make(map[string]int)
+	priceCounts := make([]int, len(idx.priceBuckets)+1)
+	var hits []hit
+
+	for productID, doc := range idx.docs {
+		score, ok := scores[productID]
+		if scores != nil && !ok {
+			continue
+		}
+
+		product := &doc.product
+		if query.InStock && product.AvailableStock() <= 0 {
+			continue
+		}
+
+		inCategory := query.Category == "" || product.Category == query.Category
+		inPrice := (query.MinPrice == nil || product.Price >= *query.MinPrice) && (query.MaxPrice == nil || product.Price <= *query.MaxPrice)
+
+		if inPrice && product.Category != "" {
+			categoryCounts[product.Category]++
+		}
+		if inCategory {
+			priceCounts[idx.priceBucket(product.Price)]++
+		}
+		if inCategory && inPrice {
+			hits = append(hits, hit{product: product, score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		if hits[i].product.Name != hits[j].product.Name {
+			return hits[i].product.Name < hits[j].product.Name
+		}
+		return hits[i].product.ID < hits[j].product.ID
+	})
+
+	result := &Result{
+		Products: []*models.Product{},
+		Total:    len(hits),
+		Facets: models.SearchFacets{
+			Categories: categoryFacets(categoryCounts),
+			Prices:     idx.priceFacets(priceCounts),
+		},
+	}
+	for i := query.Offset; i < len(hits) && i < query.Offset+query.Limit; i++ {
+		// Copies, the indexed products may be replaced once the lock is released
+		product := *hits[i].product
+		result.Products = append(result.Products, &product)
+	}
+	return result
+}
+
+// Suggest returns the products best matching text as it is being typed, for autocomplete
+func (idx *Index) Suggest(text string, limit int) []*models.ProductSuggestion {
+	suggestions := []*models.ProductSuggestion{}
+	if len(tokenize(text)) == 0 {
+		return suggestions
+	}
+
+	result := idx.Search(&Query{Text: text, Limit: limit})
+	for _, product := range result.Products {
+		suggestions = append(suggestions, &models.ProductSuggestion{ID: product.ID, Name: product.Name})
+	}
+	return suggestions
+}
+
+func (idx *Index) priceBucket(price float64) int {
+	return sort.Search(len(idx.priceBuckets), func(i int) bool { return price < idx.priceBuckets[i] })
+}
+
+func (idx *Index) priceFacets(counts []int) []models.PriceBucketCount {
+	facets := make([]models.PriceBucketCount, 0, len(counts))
+	for i, count := range counts {
+		bucket := models.PriceBucketCount{Count: count}
+		if i > 0 {
+			bucket.Min = idx.priceBuckets[i-1]
+		}
+		if i < len(idx.priceBuckets) {
+			max := idx.priceBuckets[i]
+			bucket.Max = &max
+		}
+		facets = append(facets, bucket)
+	}
+	return facets
+}
+
+// categoryFacets orders categories by count, then name
+func categoryFacets(counts map[string]int) []models.FacetCount {
+	facets := make([]models.FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, models.FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	return facets
+}
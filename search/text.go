@@ -0,0 +1,65 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// maxEdits is the number of typos tolerated in a query word. Short words must match
+// exactly, otherwise almost every short term would be within reach.
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the optimal string alignment distance between a and b, which
+// counts insertions, deletions, substitutions and transpositions of adjacent letters.
+// It gives up and returns max+1 as soon as the distance exceeds max.
+func editDistance(a, b string, max int) int {
+	s, t := []rune(a), []rune(b)
+	if diff := len(s) - len(t); diff > max || -diff > max {
+		return max + 1
+	}
+
+	// Three rows are enough: the previous two for transpositions and the current one
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(t)]
+}
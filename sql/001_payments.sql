@@ -0,0 +1,13 @@
+-- payments table
+-- Brings the payments definition in 000_initial_schema.sql in line with the ids and column
+-- names used by database/dao/payment.go. Existing payment records are kept.
+-- The foreign key on order_id is dropped while the column changes type and added back
+-- after, against the VARCHAR(32) ids orders are created with.
+ALTER TABLE payments DROP FOREIGN KEY payments_ibfk_1;
+ALTER TABLE payments MODIFY COLUMN id VARCHAR(32) NOT NULL;
+ALTER TABLE payments MODIFY COLUMN order_id VARCHAR(32) NOT NULL;
+ALTER TABLE payments ADD CONSTRAINT fk_payments_order_id FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE;
+ALTER TABLE payments ADD COLUMN method VARCHAR(50) AFTER transaction_id;
+ALTER TABLE payments RENAME COLUMN created_at TO created_date;
+ALTER TABLE payments RENAME COLUMN updated_at TO updated_date;
+ALTER TABLE payments ADD INDEX idx_payments_order_id (order_id, created_date);
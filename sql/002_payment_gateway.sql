@@ -0,0 +1,2 @@
+-- checkout session created at the payment gateway for each payment attempt
+ALTER TABLE payments ADD COLUMN session_id VARCHAR(100) AFTER transaction_id;
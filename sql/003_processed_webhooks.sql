@@ -0,0 +1,8 @@
+-- processed_webhooks table
+-- One row per gateway transaction so redelivered webhooks are acknowledged but not applied twice.
+CREATE TABLE processed_webhooks (
+    transaction_id VARCHAR(100) PRIMARY KEY,
+    order_id VARCHAR(32) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    processed_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
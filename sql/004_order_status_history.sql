@@ -0,0 +1,16 @@
+-- order statuses follow the state machine in models/order.go
+ALTER TABLE orders MODIFY COLUMN status VARCHAR(20) NOT NULL DEFAULT 'pending';
+UPDATE orders SET status = 'paid' WHERE status = 'completed';
+
+-- order_status_history table
+CREATE TABLE order_status_history (
+    id VARCHAR(32) PRIMARY KEY,
+    order_id VARCHAR(32) NOT NULL,
+    from_status VARCHAR(20) NOT NULL DEFAULT '',
+    to_status VARCHAR(20) NOT NULL,
+    actor VARCHAR(64) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_order_status_history_order_id (order_id, created_date),
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
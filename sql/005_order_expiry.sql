@@ -0,0 +1,5 @@
+-- lets the reservation sweeper find old pending orders quickly
+CREATE INDEX idx_orders_status_created_date ON orders (status, created_date);
+
+-- payment attempts of expired orders
+ALTER TABLE payments MODIFY COLUMN payment_status ENUM('pending', 'success', 'failed', 'expired') DEFAULT 'pending';
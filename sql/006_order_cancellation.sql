@@ -0,0 +1,3 @@
+-- refunds issued when a paid order is canceled
+ALTER TABLE payments ADD COLUMN refund_id VARCHAR(100) AFTER session_id;
+ALTER TABLE payments MODIFY COLUMN payment_status ENUM('pending', 'success', 'failed', 'expired', 'refunded') DEFAULT 'pending';
@@ -0,0 +1,5 @@
+-- product name as it was when the order was placed
+ALTER TABLE order_items ADD COLUMN product_name VARCHAR(100) AFTER product_id;
+
+-- GET /orders lists a user's orders newest first
+CREATE INDEX idx_orders_user_created_date ON orders (user_id, created_date, id);
@@ -0,0 +1,5 @@
+-- role carried in the JWT and checked by middleware.RequireRole
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'customer' AFTER password;
+
+-- promote an account to admin
+-- UPDATE users SET role = 'admin' WHERE email = '<admin email>';
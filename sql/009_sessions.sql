@@ -0,0 +1,27 @@
+-- login sessions, revoking one logs out every token issued from it
+CREATE TABLE sessions (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_date TIMESTAMP NULL,
+    INDEX idx_sessions_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- refresh_tokens table, only the SHA-256 of each token is stored
+CREATE TABLE refresh_tokens (
+    id VARCHAR(32) PRIMARY KEY,
+    session_id VARCHAR(32) NOT NULL,
+    user_id VARCHAR(32) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_date TIMESTAMP NOT NULL,
+    used_date TIMESTAMP NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+-- access tokens revoked before they expire
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(32) PRIMARY KEY,
+    expires_date TIMESTAMP NOT NULL
+);
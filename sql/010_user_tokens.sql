@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE AFTER role;
+
+-- user_tokens table, single use tokens for password reset and email verification.
+-- Only the SHA-256 of each token is stored.
+CREATE TABLE user_tokens (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_date TIMESTAMP NOT NULL,
+    used_date TIMESTAMP NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_tokens_user_purpose (user_id, purpose),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,18 @@
+-- login_attempts table, failed logins per account ("account:<email>") and per client ("ip:<address>")
+CREATE TABLE login_attempts (
+    attempt_key VARCHAR(150) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failed_date TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NULL
+);
+
+-- security_audit_log table
+CREATE TABLE security_audit_log (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NULL,
+    event VARCHAR(50) NOT NULL,
+    detail VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_security_audit_log_user_id (user_id, created_date)
+);
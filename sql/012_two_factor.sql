@@ -0,0 +1,25 @@
+-- user_two_factor table, TOTP secrets encrypted with auth.two_factor.encryption_key.
+-- enabled_date is set once the user confirmed a first code.
+CREATE TABLE user_two_factor (
+    user_id VARCHAR(32) PRIMARY KEY,
+    secret_encrypted VARCHAR(255) NOT NULL,
+    enabled_date TIMESTAMP NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- user_recovery_codes table, only the bcrypt hash of each code is stored
+CREATE TABLE user_recovery_codes (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    used_date TIMESTAMP NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_recovery_codes_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- whether the login that opened a session completed a second factor, carried in the
+-- "mfa" claim of every access token issued for the session
+ALTER TABLE sessions ADD COLUMN two_factor BOOLEAN NOT NULL DEFAULT FALSE AFTER user_id;
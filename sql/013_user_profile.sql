@@ -0,0 +1,5 @@
+-- email address a user is changing to, it replaces email once verified
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(100) NULL AFTER email;
+
+-- closed accounts are kept, anonymised, so that their orders still reference them
+ALTER TABLE users ADD COLUMN deleted_date TIMESTAMP NULL;
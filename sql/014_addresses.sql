@@ -0,0 +1,30 @@
+-- addresses table, a user's address book
+CREATE TABLE addresses (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL,
+    full_name VARCHAR(100) NOT NULL,
+    phone VARCHAR(20) NOT NULL DEFAULT '',
+    line1 VARCHAR(255) NOT NULL,
+    line2 VARCHAR(255) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    state VARCHAR(100) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL,
+    country CHAR(2) NOT NULL,
+    default_shipping BOOLEAN NOT NULL DEFAULT FALSE,
+    default_billing BOOLEAN NOT NULL DEFAULT FALSE,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_addresses_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- copy of the shipping address taken when the order was placed, NULL for older orders
+ALTER TABLE orders
+    ADD COLUMN shipping_full_name VARCHAR(100) NULL,
+    ADD COLUMN shipping_phone VARCHAR(20) NULL,
+    ADD COLUMN shipping_line1 VARCHAR(255) NULL,
+    ADD COLUMN shipping_line2 VARCHAR(255) NULL,
+    ADD COLUMN shipping_city VARCHAR(100) NULL,
+    ADD COLUMN shipping_state VARCHAR(100) NULL,
+    ADD COLUMN shipping_postal_code VARCHAR(20) NULL,
+    ADD COLUMN shipping_country CHAR(2) NULL;
@@ -0,0 +1,9 @@
+-- version is incremented on every catalogue edit and sent as the ETag of a product,
+-- PUT, PATCH and DELETE /products/{id} only apply to the version given in If-Match
+ALTER TABLE products ADD COLUMN version INT NOT NULL DEFAULT 1 AFTER category;
+
+-- deleted products are kept so that order_items still resolve
+ALTER TABLE products ADD COLUMN deleted_date TIMESTAMP NULL;
+
+-- updated_date is maintained by the database
+ALTER TABLE products MODIFY COLUMN updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;
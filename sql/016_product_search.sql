@@ -0,0 +1,8 @@
+-- free text search of GET /products
+ALTER TABLE products ADD FULLTEXT INDEX ft_products_name_description (name, description);
+
+-- filters and sort orders of GET /products
+CREATE INDEX idx_products_category ON products (category);
+CREATE INDEX idx_products_created_date ON products (created_date, id);
+CREATE INDEX idx_products_price ON products (price, id);
+CREATE INDEX idx_products_name ON products (name, id);
@@ -0,0 +1,20 @@
+-- merge the duplicate lines created when adding a product that was already in the cart
+UPDATE carts c
+INNER JOIN (
+    SELECT user_id, product_id, MIN(id) AS keep_id, SUM(quantity) AS quantity
+    FROM carts GROUP BY user_id, product_id HAVING COUNT(*) > 1
+) d ON d.keep_id = c.id
+SET c.quantity = d.quantity;
+
+DELETE c FROM carts c
+INNER JOIN (
+    SELECT user_id, product_id, MIN(id) AS keep_id
+    FROM carts GROUP BY user_id, product_id
+) d ON d.user_id = c.user_id AND d.product_id = c.product_id AND d.keep_id <> c.id;
+
+-- one line per product, adding again updates the quantity
+ALTER TABLE carts
+    ADD COLUMN updated_date TIMESTAMP NULL DEFAULT NULL AFTER created_date,
+    ADD UNIQUE KEY uq_carts_user_product (user_id, product_id);
+
+UPDATE carts SET updated_date = created_date;
@@ -0,0 +1,21 @@
+-- carts of shoppers who are not signed in, found by the hash of the token given to the client
+CREATE TABLE guest_carts (
+    id VARCHAR(32) PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL,
+    expires_date TIMESTAMP NOT NULL,
+    created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_guest_carts_token_hash (token_hash),
+    INDEX idx_guest_carts_expires_date (expires_date)
+);
+
+-- lines of guest carts, same shape as carts, merged into carts on login
+CREATE TABLE guest_cart_items (
+    id VARCHAR(32) PRIMARY KEY,
+    guest_cart_id VARCHAR(32) NOT NULL,
+    product_id VARCHAR(32) NOT NULL,
+    quantity INT NOT NULL,
+    created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_date TIMESTAMP NULL DEFAULT NULL,
+    UNIQUE KEY uq_guest_cart_items_cart_product (guest_cart_id, product_id),
+    FOREIGN KEY (guest_cart_id) REFERENCES guest_carts(id) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+-- price of a cart line when the customer last set it, checkout rejects lines whose price moved
+ALTER TABLE carts ADD COLUMN price_at_add DECIMAL(10, 2) NULL AFTER quantity;
+ALTER TABLE guest_cart_items ADD COLUMN price_at_add DECIMAL(10, 2) NULL AFTER quantity;
+
+UPDATE carts c INNER JOIN products p ON p.id = c.product_id SET c.price_at_add = p.price;
+UPDATE guest_cart_items gci INNER JOIN products p ON p.id = gci.product_id SET gci.price_at_add = p.price;
+
+-- incremented on every cart edit, checkout compares it with the version the customer confirmed
+CREATE TABLE cart_versions (
+    user_id VARCHAR(32) PRIMARY KEY,
+    version INT NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE guest_carts ADD COLUMN version INT NOT NULL DEFAULT 0;
@@ -0,0 +1,31 @@
+-- named wishlists, share_token is set while a list is shared through a public link
+CREATE TABLE wishlists (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    share_token VARCHAR(64) NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_wishlists_share_token (share_token),
+    INDEX idx_wishlists_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE wishlist_items (
+    wishlist_id VARCHAR(32) NOT NULL,
+    product_id VARCHAR(32) NOT NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (wishlist_id, product_id),
+    INDEX idx_wishlist_items_product_id (product_id),
+    FOREIGN KEY (wishlist_id) REFERENCES wishlists(id) ON DELETE CASCADE
+);
+
+-- cart lines saved for later, moved back to carts with their quantity
+CREATE TABLE saved_items (
+    user_id VARCHAR(32) NOT NULL,
+    product_id VARCHAR(32) NOT NULL,
+    quantity INT NOT NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, product_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,31 @@
+-- time of the last cart edit, removed lines included, idle carts are measured from it
+ALTER TABLE cart_versions ADD COLUMN updated_date TIMESTAMP NULL;
+
+-- users who unsubscribed from abandoned cart reminder emails
+ALTER TABLE users ADD COLUMN cart_reminders_opt_out BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- one row per abandoned cart email, the unique key keeps instances running the job
+-- from sending the same reminder twice
+CREATE TABLE cart_reminders (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL,
+    cart_version INT NOT NULL,
+    reminder_number INT NOT NULL,
+    restore_token_hash CHAR(64) NOT NULL,
+    unsubscribe_token_hash CHAR(64) NOT NULL,
+    expires_date TIMESTAMP NOT NULL,
+    created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_cart_reminders_number (user_id, cart_version, reminder_number),
+    UNIQUE KEY uq_cart_reminders_restore_token (restore_token_hash),
+    UNIQUE KEY uq_cart_reminders_unsubscribe_token (unsubscribe_token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- the cart lines a reminder listed, brought back by its restore link
+CREATE TABLE cart_reminder_items (
+    reminder_id VARCHAR(32) NOT NULL,
+    product_id VARCHAR(32) NOT NULL,
+    quantity INT NOT NULL,
+    PRIMARY KEY (reminder_id, product_id),
+    FOREIGN KEY (reminder_id) REFERENCES cart_reminders(id) ON DELETE CASCADE
+);
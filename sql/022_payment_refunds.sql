@@ -0,0 +1,3 @@
+-- payments captured for orders that can no longer be fulfilled wait in refund_pending
+-- until the gateway confirms the refund
+ALTER TABLE payments MODIFY COLUMN payment_status ENUM('pending', 'success', 'failed', 'expired', 'refund_pending', 'refunded') DEFAULT 'pending';
@@ -0,0 +1,9 @@
+-- last back in stock email per user and product, a product whose stock keeps being
+-- reserved and released only alerts a watcher again after the cooldown
+CREATE TABLE wishlist_alerts (
+    user_id VARCHAR(32) NOT NULL,
+    product_id VARCHAR(32) NOT NULL,
+    back_in_stock_date TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, product_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const cursorSeparator = "|"
+
+// EncodeCursor builds an opaque pagination cursor from the sort key of the last row returned
+func EncodeCursor(parts ...string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(parts, cursorSeparator)))
+}
+
+// DecodeCursor returns the parts passed to EncodeCursor. An error is returned if the
+// cursor is malformed or does not contain the expected number of parts.
+func DecodeCursor(cursor string, expectedParts int) ([]string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(decoded), cursorSeparator, expectedParts)
+	if len(parts) != expectedParts {
+		return nil, errors.New("invalid cursor")
+	}
+	return parts, nil
+}
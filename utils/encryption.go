@@ -3,52 +3,59 @@ package utils
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/hex"
-	"log"
+	"fmt"
 )
 
+// Encrypt seals data with AES-GCM. The key must be 16, 24 or 32 bytes long; a random
+// nonce is prepended to the hex encoded ciphertext.
 func Encrypt(data, key string) (string, error) {
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
-		log.Fatalf("Error creating cipher: %v", err)
-		return "", err
+		return "", fmt.Errorf("error creating cipher: %w", err)
 	}
 
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		log.Fatalf("Error creating GCM: %v", err)
-		return "", err
+		return "", fmt.Errorf("error creating GCM: %w", err)
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+
 	ciphertext := gcm.Seal(nonce, nonce, []byte(data), nil)
 	return hex.EncodeToString(ciphertext), nil
 }
 
+// Decrypt opens data sealed by Encrypt with the same key
 func Decrypt(encryptedData, key string) (string, error) {
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
-		log.Fatalf("Error creating cipher: %v", err)
-		return "", err
+		return "", fmt.Errorf("error creating cipher: %w", err)
 	}
 
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		log.Fatalf("Error creating GCM: %v", err)
-		return "", err
+		return "", fmt.Errorf("error creating GCM: %w", err)
 	}
 
 	data, err := hex.DecodeString(encryptedData)
 	if err != nil {
-		log.Fatalf("Error decoding hex: %v", err)
-		return "", err
+		return "", fmt.Errorf("error decoding hex: %w", err)
 	}
 
 	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		log.Fatalf("Error decrypting: %v", err)
+		return "", fmt.Errorf("error decrypting: %w", err)
 	}
 
 	return string(plaintext), nil
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"ecommerce/models"
 	"errors"
 	"log"
 	"time"
@@ -8,23 +9,37 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWTSecret is the secret key for signing the token. Store it securely!
-var JWTSecret = []byte("your-very-secure-secret")
+// AccessTokenTTL is the lifetime of tokens issued by GenerateJWT
+var AccessTokenTTL = time.Hour
 
-// GenerateJWT generates a JWT for a given user ID
-func GenerateJWT(userID string) (string, error) {
+// RefreshTokenTTL is the lifetime of the refresh tokens issued alongside them
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// IsTokenRevoked is consulted by ValidateJWT for every token. It is set at start up
+// to the database backed revocation store; when nil no revocation check is made.
+var IsTokenRevoked func(tokenID, sessionID string) (bool, error)
+
+// GenerateJWT generates a JWT for a given user ID and role within a login session.
+// twoFactor records whether the login completed a second factor.
+func GenerateJWT(userID, role, sessionID string, twoFactor bool) (string, error) {
 	// Define token claims
 	claims := jwt.MapClaims{
-		"user_id": userID,                           // Include user-specific data
-		"exp":     time.Now().Add(time.Hour).Unix(), // Set expiration time
-		"iat":     time.Now().Unix(),                // Issued at time
+		"user_id": userID,                                // Include user-specific data
+		"role":    role,                                  // Checked by middleware.RequireRole
+		"sid":     sessionID,                             // Session the token was issued for
+		"mfa":     twoFactor,                             // Checked for admins by middleware.RequireRole
+		"jti":     NewID(),                               // Unique token ID, used to revoke it
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(), // Set expiration time
+		"iat":     time.Now().Unix(),                     // Issued at time
 	}
 
-	// Create the token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Create the token with claims, the kid header tells verifiers which key to use
+	signingKey := JWTKeys.signing
+	token := jwt.NewWithClaims(signingKey.signingMethod(), claims)
+	token.Header["kid"] = signingKey.ID
 
-	// Sign the token with the secret
-	signedToken, err := token.SignedString(JWTSecret)
+	// Sign the token with the private key
+	signedToken, err := token.SignedString(signingKey.PrivateKey)
 	if err != nil {
 		return "", err
 	}
@@ -32,21 +47,37 @@ func GenerateJWT(userID string) (string, error) {
 	return signedToken, nil
 }
 
+// Claims holds the values of a validated token that handlers rely on
+type Claims struct {
+	UserID    string
+	Role      string
+	TokenID   string
+	SessionID string
+	TwoFactor bool
+	ExpiresAt time.Time
+}
+
 // ValidateJWT validates the JWT token by parsing and checking its signature and claims.
-func ValidateJWT(tokenStr string) bool {
+// It returns the claims of the token when it is valid and has not been revoked.
+func ValidateJWT(tokenStr string) (*Claims, bool) {
 	// Parse the JWT token
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		// Ensure that the token's signing method is HMAC (HS256 in this case)
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// Look the verification key up by kid and ensure the token uses its algorithm
+		keyID, _ := token.Header["kid"].(string)
+		key, ok := JWTKeys.Key(keyID)
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		if token.Method.Alg() != key.Algorithm {
 			return nil, errors.New("invalid signing method")
 		}
-		return JWTSecret, nil
-	})
+		return key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}))
 
 	// If there's an error or the token is invalid, return false
 	if err != nil {
 		log.Println("Error parsing token:", err)
-		return false
+		return nil, false
 	}
 
 	// Check if the token is valid (including expiration and other claims)
@@ -55,18 +86,53 @@ func ValidateJWT(tokenStr string) bool {
 		expirationTime, ok := claims["exp"].(float64)
 		if !ok {
 			log.Println("Token does not have an expiration time.")
-			return false
+			return nil, false
 		}
 
 		// Check if the token has expired
 		if time.Now().Unix() > int64(expirationTime) {
 			log.Println("Token has expired.")
-			return false
+			return nil, false
+		}
+
+		userID, _ := claims["user_id"].(string)
+		tokenID, _ := claims["jti"].(string)
+		sessionID, _ := claims["sid"].(string)
+		if userID == "" || tokenID == "" || sessionID == "" {
+			log.Println("Token is missing user, token or session id.")
+			return nil, false
+		}
+
+		// Check if the token or its session has been revoked
+		if IsTokenRevoked != nil {
+			revoked, err := IsTokenRevoked(tokenID, sessionID)
+			if err != nil {
+				log.Println("Unable to check token revocation:", err)
+				return nil, false
+			}
+			if revoked {
+				log.Println("Token has been revoked.")
+				return nil, false
+			}
+		}
+
+		// Tokens issued before roles were added belong to customers
+		role, _ := claims["role"].(string)
+		twoFactor, _ := claims["mfa"].(bool)
+		if role == "" {
+			role = models.RoleCustomer
 		}
 
-		return true
+		return &Claims{
+			UserID:    userID,
+			Role:      role,
+			TokenID:   tokenID,
+			SessionID: sessionID,
+			TwoFactor: twoFactor,
+			ExpiresAt: time.Unix(int64(expirationTime), 0),
+		}, true
 	}
 
 	log.Println("Invalid token claims.")
-	return false
+	return nil, false
 }
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported token signing algorithms
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// JWTKeyConfig describes one key under jwt.keys in config.yaml. Keys are given either
+// inline as PEM or as a path to a PEM file. Keys that are only kept to verify tokens
+// issued before a rotation need no private key.
+type JWTKeyConfig struct {
+	ID             string `mapstructure:"id"`
+	Algorithm      string `mapstructure:"algorithm"`
+	PrivateKey     string `mapstructure:"private_key"`
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKey      string `mapstructure:"public_key"`
+	PublicKeyFile  string `mapstructure:"public_key_file"`
+}
+
+// JWTKey is a parsed signing or verification key
+type JWTKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+func (k *JWTKey) signingMethod() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are accepted from
+type KeySet struct {
+	signing *JWTKey
+	keys    map[string]*JWTKey
+}
+
+// JWTKeys is used by GenerateJWT and ValidateJWT, it is loaded at start up
+var JWTKeys *KeySet
+
+// LoadJWTKeys parses the configured keys. When no key is configured an ephemeral
+// Ed25519 key is generated so that the service can run locally, tokens signed with
+// it stop being valid on restart.
+func LoadJWTKeys(signingKeyID string, configs []JWTKeyConfig) (*KeySet, error) {
+	if len(configs) == 0 {
+		log.Println("No JWT keys configured, generating an ephemeral signing key. Do not use this in production!")
+		return ephemeralKeySet()
+	}
+
+	keySet := &KeySet{keys: make(map[string]*JWTKey)}
+	for _, config := range configs {
+		key, err := parseJWTKey(&config)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", config.ID, err)
+		}
+		if _, ok := keySet.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate jwt key id : %s", key.ID)
+		}
+		keySet.keys[key.ID] = key
+	}
+
+	signing, ok := keySet.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not configured", signingKeyID)
+	}
+	if signing.PrivateKey == nil {
+		return nil, fmt.Errorf("signing key %s has no private key", signingKeyID)
+	}
+	keySet.signing = signing
+
+	return keySet, nil
+}
+
+// Key returns the verification key with the given key ID
+func (k *KeySet) Key(keyID string) (*JWTKey, bool) {
+	key, ok := k.keys[keyID]
+	return key, ok
+}
+
+func parseJWTKey(config *JWTKeyConfig) (*JWTKey, error) {
+	if config.ID == "" {
+		return nil, fmt.Errorf("missing key id")
+	}
+
+	key := &JWTKey{ID: config.ID, Algorithm: config.Algorithm}
+	if key.Algorithm != AlgorithmRS256 && key.Algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported algorithm : %s", config.Algorithm)
+	}
+
+	privatePEM, err := readPEM(config.PrivateKey, config.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := readPEM(config.PublicKey, config.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if privatePEM != nil {
+		if key.Algorithm == AlgorithmRS256 {
+			key.PrivateKey, err = jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+		} else {
+			var private crypto.PrivateKey
+			private, err = jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+			if err == nil {
+				key.PrivateKey = private.(ed25519.PrivateKey)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		key.PublicKey = key.PrivateKey.Public()
+	}
+
+	if publicPEM != nil {
+		if key.Algorithm == AlgorithmRS256 {
+			key.PublicKey, err = jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+		} else {
+			key.PublicKey, err = jwt.ParseEdPublicKeyFromPEM(publicPEM)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+	}
+
+	if key.PublicKey == nil {
+		return nil, fmt.Errorf("neither a private nor a public key is configured")
+	}
+	return key, nil
+}
+
+func readPEM(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+func ephemeralKeySet() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &JWTKey{
+		ID:         "ephemeral-" + NewID()[:8],
+		Algorithm:  AlgorithmEdDSA,
+		PrivateKey: private,
+		PublicKey:  public,
+	}
+	return &KeySet{signing: key, keys: map[string]*JWTKey{key.ID: key}}, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every verification key so that other services can verify tokens
+func (k *KeySet) JWKS() *JWKSet {
+	set := &JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+
+		switch public := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"strings"
 
 	"github.com/google/uuid"
@@ -11,3 +13,12 @@ func NewID() string {
 	newID := strings.ReplaceAll(id, "-", "")
 	return newID
 }
+
+// NewToken returns a random URL safe token suitable for refresh or reset links
+func NewToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
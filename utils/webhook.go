@@ -5,51 +5,82 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"time"
 )
 
-// Secret key shared between your server and the webhook provider.
-var secretKey = []byte("your-secret-key")
+// WebhookVerifier checks that webhook requests were signed by the payment gateway.
+// More than one secret can be configured so that a rotated secret keeps working
+// until the gateway has switched over to the new one.
+type WebhookVerifier struct {
+	secrets   [][]byte
+	tolerance time.Duration
+}
+
+func NewWebhookVerifier(secrets []string, tolerance time.Duration) *WebhookVerifier {
+	verifier := &WebhookVerifier{tolerance: tolerance}
+	for _, secret := range secrets {
+		if secret != "" {
+			verifier.secrets = append(verifier.secrets, []byte(secret))
+		}
+	}
+	return verifier
+}
+
+// Verify authenticates the webhook request and returns its body, which has been
+// consumed from r.Body by the time Verify returns.
+func (v *WebhookVerifier) Verify(r *http.Request) ([]byte, error) {
+	if len(v.secrets) == 0 {
+		return nil, errors.New("no webhook secret configured")
+	}
 
-// VerifyWebhook verifies the authenticity of the webhook request.
-func VerifyWebhook(r *http.Request) error {
 	// Step 1: Read the request body
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return errors.New("failed to read request body")
+		return nil, errors.New("failed to read request body")
 	}
 	defer r.Body.Close()
 
-	// Step 2: Get the signature from headers
+	// Step 2: Get the signature and timestamp from headers
 	signature := r.Header.Get("X-Signature")
 	if signature == "" {
-		return errors.New("missing signature header")
+		return nil, errors.New("missing signature header")
 	}
 
-	// Step 3: Generate HMAC-SHA256 of the body using the secret key
-	hash := hmac.New(sha256.New, secretKey)
-	hash.Write(body)
-	expectedSignature := hex.EncodeToString(hash.Sum(nil))
+	timestamp := r.Header.Get("X-Timestamp")
+	if timestamp == "" {
+		return nil, errors.New("missing timestamp header")
+	}
 
-	// Step 4: Compare the signature with the expected value
-	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-		return errors.New("invalid signature")
+	// Step 3: Reject requests outside the tolerance window to limit replays
+	reqTime, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return nil, errors.New("invalid timestamp format")
 	}
 
-	// Step 5: (Optional) Validate the timestamp
-	timestamp := r.Header.Get("X-Timestamp")
-	if timestamp != "" {
-		reqTime, err := time.Parse(time.RFC3339, timestamp)
-		if err != nil {
-			return errors.New("invalid timestamp format")
-		}
+	age := time.Since(reqTime)
+	if age > v.tolerance || age < -v.tolerance {
+		return nil, errors.New("webhook request expired")
+	}
 
-		if time.Since(reqTime) > 5*time.Minute {
-			return errors.New("webhook request expired")
+	// Step 4: Compare the signature with the one expected for every active secret
+	for _, secret := range v.secrets {
+		expectedSignature := SignWebhook(secret, timestamp, body)
+		if hmac.Equal([]byte(signature), []byte(expectedSignature)) {
+			return body, nil
 		}
 	}
 
-	return nil
+	return nil, errors.New("invalid signature")
+}
+
+// SignWebhook returns the hex encoded HMAC-SHA256 signature of "<timestamp>.<body>".
+// Signing the timestamp stops an attacker from replaying an old body with a fresh one.
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	hash := hmac.New(sha256.New, secret)
+	hash.Write([]byte(timestamp))
+	hash.Write([]byte("."))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
 }